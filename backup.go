@@ -0,0 +1,88 @@
+package dbhub
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BackupAll downloads every database in your account into the given directory, running up to concurrency downloads
+// at once.  A result is returned for each database, holding the path of the saved file or the error which prevented
+// it from being saved.
+func (c Connection) BackupAll(dir string, concurrency int) (results []BackupResult, err error) {
+	return c.BackupAllContext(context.Background(), dir, concurrency)
+}
+
+// BackupAllContext is like BackupAll, but uses the given context for the requests.  When the context is cancelled,
+// downloads not yet started are skipped and marked with the context error.
+func (c Connection) BackupAllContext(ctx context.Context, dir string, concurrency int) (results []BackupResult, err error) {
+	// Retrieve the list of databases to back up
+	databases, err := c.DatabasesContext(ctx)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Download each database, with at most "concurrency" downloads in progress at any one time.  The results are
+	// stored in the same order as the database list
+	results = make([]BackupResult, len(databases))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, dbName := range databases {
+		results[i].DBName = dbName
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(r *BackupResult) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			r.Path, r.Err = c.backupOne(ctx, dir, r.DBName)
+		}(&results[i])
+	}
+	wg.Wait()
+	err = ctx.Err()
+	return
+}
+
+// backupOne downloads a single database from your account into the given directory, returning the path it was saved
+// to.  Partially written files are removed if the download fails
+func (c Connection) backupOne(ctx context.Context, dir, dbName string) (path string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	db, err := c.DownloadContext(ctx, "", dbName, Identifier{})
+	if db != nil {
+		defer db.Close()
+	}
+	if err != nil {
+		return
+	}
+
+	// Save the database file
+	path = filepath.Join(dir, filepath.Base(dbName))
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(f, db)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		path = ""
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package dbhub
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchQuery runs a list of SQL queries (SELECT only), which can be on different databases, with up to concurrency
+// queries running at once.  A result is returned for each query in the same order as the list, holding either its
+// results or the error which stopped it.  BLOB fields are handled as given by the DefaultBlobBase64 setting of the
+// connection.
+func (c Connection) BatchQuery(queries []BatchQueryItem, concurrency int) (results []BatchQueryResult, err error) {
+	return c.BatchQueryContext(context.Background(), queries, concurrency)
+}
+
+// BatchQueryContext is like BatchQuery, but uses the given context for the requests.  When the context is cancelled,
+// queries not yet started are skipped and marked with the context error.
+func (c Connection) BatchQueryContext(ctx context.Context, queries []BatchQueryItem, concurrency int) (results []BatchQueryResult, err error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Run each query, with at most "concurrency" queries in progress at any one time
+	results = make([]BatchQueryResult, len(queries))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(r *BatchQueryResult, q BatchQueryItem) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			r.Results, r.Err = c.QueryContext(ctx, q.DBOwner, q.DBName, q.Ident, c.DefaultBlobBase64, q.SQL)
+		}(&results[i], q)
+	}
+	wg.Wait()
+	err = ctx.Err()
+	return
+}
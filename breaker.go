@@ -0,0 +1,96 @@
+package dbhub
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops requests being sent to a server which keeps failing.  After Threshold consecutive failed
+// requests (network errors, or server side errors) the breaker opens, and requests fail straight away with
+// ErrCircuitOpen.  Once Cooldown has passed, a single request is let through to probe the server.  If it succeeds
+// the breaker closes again, otherwise it stays open for another cool down period.
+//
+// A CircuitBreaker can be shared by several connections, and is safe for concurrent use.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates a circuit breaker which opens after threshold consecutive failures, probing the server
+// again after cooldown
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Open returns true if the breaker is currently rejecting requests
+func (b *CircuitBreaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.isOpen()
+}
+
+// isOpen returns true if the breaker has tripped.  The mutex must be held by the caller
+func (b *CircuitBreaker) isOpen() bool {
+	return b.Threshold > 0 && b.failures >= b.Threshold
+}
+
+// allow returns ErrCircuitOpen if a request shouldn't be sent right now
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.isOpen() {
+		return nil
+	}
+
+	// Let a single probe request through once the cool down period is over
+	if b.probing || time.Since(b.openedAt) < b.Cooldown {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record updates the breaker with the outcome of a request
+func (b *CircuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.isOpen() {
+		b.openedAt = time.Now()
+	}
+}
+
+// release updates the breaker for a request with no meaningful outcome (eg cancelled by the caller)
+func (b *CircuitBreaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// serverFailed returns true if a request failed due to a network or server side problem
+func serverFailed(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
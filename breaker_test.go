@@ -0,0 +1,78 @@
+package dbhub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// Each step either records the outcome of a request (after checking whether it's allowed), or waits
+	type step struct {
+		wait      time.Duration
+		failed    bool
+		wantAllow bool
+	}
+	tests := []struct {
+		name     string
+		steps    []step
+		wantOpen bool
+	}{
+		{"stays closed below threshold", []step{{failed: true, wantAllow: true}, {failed: true, wantAllow: true}},
+			false},
+		{"opens at threshold", []step{{failed: true, wantAllow: true}, {failed: true, wantAllow: true},
+			{failed: true, wantAllow: true}, {wantAllow: false}}, true},
+		{"success resets the count", []step{{failed: true, wantAllow: true}, {failed: true, wantAllow: true},
+			{wantAllow: true}, {failed: true, wantAllow: true}, {failed: true, wantAllow: true}}, false},
+		{"probe after cooldown closes", []step{{failed: true, wantAllow: true}, {failed: true, wantAllow: true},
+			{failed: true, wantAllow: true}, {wait: 30 * time.Millisecond, wantAllow: true}, {wantAllow: true}}, false},
+		{"failed probe reopens", []step{{failed: true, wantAllow: true}, {failed: true, wantAllow: true},
+			{failed: true, wantAllow: true}, {wait: 30 * time.Millisecond, failed: true, wantAllow: true},
+			{wantAllow: false}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(3, 20*time.Millisecond)
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+				err := b.allow()
+				if (err == nil) != s.wantAllow {
+					t.Fatalf("step %d: got %v, want allowed %v", i, err, s.wantAllow)
+				}
+				if err == nil {
+					b.record(s.failed)
+				}
+			}
+			if b.Open() != tt.wantOpen {
+				t.Errorf("breaker open is %v, want %v", b.Open(), tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	b := NewCircuitBreaker(1, 0)
+	b.record(true)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe not allowed: %v", err)
+	}
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Errorf("second request during the probe got %v, want ErrCircuitOpen", err)
+	}
+
+	// A cancelled probe lets another one through
+	b.release()
+	if err := b.allow(); err != nil {
+		t.Errorf("probe not allowed after release: %v", err)
+	}
+}
+
+func TestCircuitBreakerNil(t *testing.T) {
+	var b *CircuitBreaker
+	if err := b.allow(); err != nil {
+		t.Errorf("nil breaker rejected a request: %v", err)
+	}
+	b.record(true)
+	if b.Open() {
+		t.Error("nil breaker is open")
+	}
+}
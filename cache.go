@@ -0,0 +1,246 @@
+package dbhub
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sync"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// cacheable holds the API end points whose responses are cached, when the connection has a cache
+var cacheable = map[string]bool{
+	"columns": true,
+	"indexes": true,
+	"query":   true,
+	"tables":  true,
+	"views":   true,
+}
+
+// defaultCacheHeadTTL is how long the cache remembers the head commits of branches, when the connection doesn't say
+const defaultCacheHeadTTL = 30 * time.Second
+
+// cachedHead is the commit at the head of a branch, as remembered by the cache.  Live databases don't have commits, so
+// they're remembered as being live instead.
+type cachedHead struct {
+	Commit  string    `json:"commit,omitempty"`
+	Live    bool      `json:"live,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Cache is implemented by stores for caching responses from the DBHub.io server.  Keys are opaque strings identifying
+// a request, and values are the raw responses.  Implementations need to be safe for concurrent use.
+type Cache interface {
+	Get(key string) (value []byte, ok bool)
+	Set(key string, value []byte)
+}
+
+// MemoryCache is an in-memory Cache holding up to a fixed number of responses.  When it's full, the least recently used
+// response is dropped to make room.  It's safe for concurrent use.
+type MemoryCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used at the front
+}
+
+// memoryCacheEntry is a single response held by a MemoryCache
+type memoryCacheEntry struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCache creates an in-memory cache holding up to maxEntries responses.  If maxEntries is less than 1, there's
+// no limit.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Get returns the response cached under the key, if there is one
+func (m *MemoryCache) Get(key string) (value []byte, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return
+	}
+	m.order.MoveToFront(e)
+	value = e.Value.(*memoryCacheEntry).value
+	return
+}
+
+// Set caches a response under the key, replacing any already there
+func (m *MemoryCache) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		e.Value.(*memoryCacheEntry).value = value
+		m.order.MoveToFront(e)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&memoryCacheEntry{key: key, value: value})
+	if m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// sendRequestCached is like sendRequestJSON, but uses the cache of the connection.  Commits never change, so responses
+// are cached against the commit they're for.  Requests for the head of a branch are turned into requests for the
+// commit currently at its head, so a new commit on the branch leads to fresh responses once the remembered head
+// expires (or Branches() or Metadata() show the new head).  Requests for tags and releases, or for live databases
+// (which don't have commits), aren't cached.
+func (c Connection) sendRequestCached(ctx context.Context, queryUrl string, data url.Values, returnStructure interface{}) (err error) {
+	if data.Get("commit") == "" {
+		if data.Get("tag") != "" || data.Get("release") != "" {
+			return c.sendRequestUncached(ctx, queryUrl, data, returnStructure)
+		}
+		var head cachedHead
+		head, err = c.cachedBranchHead(ctx, data.Get("dbowner"), data.Get("dbname"), data.Get("branch"))
+		if err != nil {
+			return
+		}
+		if head.Live {
+			return c.sendRequestUncached(ctx, queryUrl, data, returnStructure)
+		}
+		pinned := url.Values{}
+		for k, v := range data {
+			pinned[k] = v
+		}
+		pinned.Del("branch")
+		pinned.Set("commit", head.Commit)
+		data = pinned
+	}
+
+	// Use the cached response if there is one
+	key := cacheKey(queryUrl, data)
+	if b, ok := c.Cache.Get(key); ok {
+		_, err = decodeResponse(queryUrl, bytes.NewReader(b), returnStructure)
+		return
+	}
+
+	// Otherwise ask the server, caching the response if it's usable
+	body, err := c.sendRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	err = contextError(ctx, queryUrl, err)
+	if err != nil {
+		return
+	}
+	_, err = decodeResponse(queryUrl, bytes.NewReader(b), returnStructure)
+	if err != nil {
+		return
+	}
+	c.Cache.Set(key, b)
+	return
+}
+
+// cachedBranchHead returns the commit at the head of a branch (the default branch if none is given), as remembered by
+// the cache.  The server is only asked when the branch head isn't remembered, or has expired.  Live databases are told
+// apart from standard ones by having no branches, or by the server saying they're live.
+func (c Connection) cachedBranchHead(ctx context.Context, dbOwner, dbName, branch string) (head cachedHead, err error) {
+	keys := []string{headCacheKey(c.APIKey, dbOwner, dbName, branch)}
+	if branch != "" {
+		// Live databases are only remembered under the default branch, as they don't have any branches
+		keys = append(keys, headCacheKey(c.APIKey, dbOwner, dbName, ""))
+	}
+	for i, key := range keys {
+		var h cachedHead
+		b, ok := c.Cache.Get(key)
+		if ok && json.Unmarshal(b, &h) == nil && time.Now().Before(h.Expires) && (i == 0 || h.Live) {
+			head = h
+			return
+		}
+	}
+
+	// Ask the server, which remembers the heads of all branches
+	branches, defaultBranch, err := c.BranchesContext(ctx, dbOwner, dbName)
+	if errors.Is(err, ErrLiveDatabase) {
+		err = nil
+		c.rememberHeads(dbOwner, dbName, nil, "")
+	}
+	if err != nil {
+		return
+	}
+	if len(branches) == 0 {
+		head = cachedHead{Live: true}
+		return
+	}
+	name := branch
+	if name == "" {
+		name = defaultBranch
+	}
+	b, ok := branches[name]
+	if !ok {
+		err = fmt.Errorf("branch '%s' not found", name)
+		return
+	}
+	head = cachedHead{Commit: b.Commit}
+	return
+}
+
+// rememberHeads records the head commits of the branches of a database in the cache of the connection (if any), with
+// the head of the default branch also recorded for requests not giving a branch.  Databases without any branches are
+// live databases, which is recorded instead.
+func (c Connection) rememberHeads(dbOwner, dbName string, branches map[string]com.BranchEntry, defaultBranch string) {
+	if c.Cache == nil {
+		return
+	}
+	ttl := c.CacheHeadTTL
+	if ttl == 0 {
+		ttl = defaultCacheHeadTTL
+	}
+	expires := time.Now().Add(ttl)
+	set := func(branch string, head cachedHead) {
+		head.Expires = expires
+		if b, err := json.Marshal(head); err == nil {
+			c.Cache.Set(headCacheKey(c.APIKey, dbOwner, dbName, branch), b)
+		}
+	}
+	if len(branches) == 0 {
+		set("", cachedHead{Live: true})
+		return
+	}
+	for name, j := range branches {
+		set(name, cachedHead{Commit: j.Commit})
+	}
+	if b, ok := branches[defaultBranch]; ok {
+		set("", cachedHead{Commit: b.Commit})
+	}
+}
+
+// sendRequestUncached sends a request to DBHub.io without using the cache of the connection
+func (c Connection) sendRequestUncached(ctx context.Context, queryUrl string, data url.Values, returnStructure interface{}) (err error) {
+	noCache := c
+	noCache.Cache = nil
+	return noCache.sendRequestJSON(ctx, queryUrl, data, returnStructure)
+}
+
+// headCacheKey returns the key the head of a branch of a database is remembered under.  An empty branch is for the
+// default branch
+func headCacheKey(apiKey, dbOwner, dbName, branch string) string {
+	v := url.Values{"apikey": {apiKey}, "dbowner": {dbOwner}, "dbname": {dbName}, "branch": {branch}}
+	sum := sha256.Sum256([]byte("head?" + v.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheKey returns the key a request is cached under.  The request parameters (including the API key) are hashed, so
+// they're not kept in the cache as they are.
+func cacheKey(queryUrl string, data url.Values) string {
+	sum := sha256.Sum256([]byte(endpointName(queryUrl) + "?" + data.Encode()))
+	return hex.EncodeToString(sum[:])
+}
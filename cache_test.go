@@ -0,0 +1,218 @@
+package dbhub_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+// branchesResponse returns a branches end point response with a single "main" branch, with its head at the commit
+func branchesResponse(commitID string) string {
+	return fmt.Sprintf(`{"branches":{"main":{"commit":%q}},"default_branch":"main"}`, commitID)
+}
+
+// countRequests returns the number of requests received by the server for each end point, along with the commit
+// given in each request to the chosen end point
+func countRequests(s *dbhubtest.Server, endpoint string) (counts map[string]int, commits []string) {
+	counts = make(map[string]int)
+	for _, req := range s.Requests() {
+		counts[req.Endpoint]++
+		if req.Endpoint == endpoint {
+			commits = append(commits, req.Form.Get("commit"))
+		}
+	}
+	return
+}
+
+// newCachedServer starts a test server, returning a connection to it with a cache
+func newCachedServer() (s *dbhubtest.Server, c dbhub.Connection) {
+	s = dbhubtest.NewServer()
+	c = s.Connection()
+	c.Cache = dbhub.NewMemoryCache(0)
+	return
+}
+
+func TestCacheRemembersBranchHead(t *testing.T) {
+	s, c := newCachedServer()
+	defer s.Close()
+	s.Handle("branches", 200, branchesResponse("c1"))
+	s.Handle("tables", 200, `["a"]`)
+
+	for i := 0; i < 3; i++ {
+		tables, err := c.Tables("me", "db.sqlite", dbhub.Identifier{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tables) != 1 || tables[0] != "a" {
+			t.Errorf("got tables %v", tables)
+		}
+	}
+	counts, commits := countRequests(s, "tables")
+	if counts["branches"] != 1 || counts["tables"] != 1 {
+		t.Errorf("got requests %v, want one each for branches and tables", counts)
+	}
+	if len(commits) != 1 || commits[0] != "c1" {
+		t.Errorf("tables requested for commits %v, want c1", commits)
+	}
+}
+
+func TestCacheHeadChanges(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		// see runs between the requests, letting the connection notice the new head commit
+		see            func(c dbhub.Connection) error
+		wantBranchReqs int
+	}{
+		{name: "head expires", ttl: 10 * time.Millisecond, see: func(c dbhub.Connection) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}, wantBranchReqs: 2},
+		{name: "metadata shows new head", ttl: time.Hour, see: func(c dbhub.Connection) error {
+			_, err := c.Metadata("me", "db.sqlite")
+			return err
+		}, wantBranchReqs: 1},
+		{name: "branches shows new head", ttl: time.Hour, see: func(c dbhub.Connection) error {
+			_, _, err := c.Branches("me", "db.sqlite")
+			return err
+		}, wantBranchReqs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newCachedServer()
+			defer s.Close()
+			c.CacheHeadTTL = tt.ttl
+			s.Handle("branches", 200, branchesResponse("c1"))
+			s.Handle("views", 200, `["v"]`)
+			_, err := c.Views("me", "db.sqlite", dbhub.Identifier{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Make a new commit, then request the views again
+			s.Handle("branches", 200, branchesResponse("c2"))
+			s.Handle("metadata", 200, `{"branches":{"main":{"commit":"c2"}},"default_branch":"main"}`)
+			if err = tt.see(c); err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.Views("me", "db.sqlite", dbhub.Identifier{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts, commits := countRequests(s, "views")
+			if counts["branches"] != tt.wantBranchReqs {
+				t.Errorf("got %d branches requests, want %d", counts["branches"], tt.wantBranchReqs)
+			}
+			if len(commits) != 2 || commits[0] != "c1" || commits[1] != "c2" {
+				t.Errorf("views requested for commits %v, want [c1 c2]", commits)
+			}
+		})
+	}
+}
+
+func TestCacheLiveDatabases(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		branches string
+	}{
+		{"no branches", 200, `{"branches":{},"default_branch":""}`},
+		{"live status", 400, `{"error":"live databases have no branches","status":"live"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newCachedServer()
+			defer s.Close()
+			s.Handle("branches", tt.status, tt.branches)
+			s.Handle("query", 200, `[[{"Name":"n","Type":4,"Value":1}]]`)
+			for i := 0; i < 2; i++ {
+				_, err := c.QueryDefault("me", "live.sqlite", dbhub.Identifier{}, "SELECT n FROM t")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Live databases change without new commits, so every query goes to the server.  Whether the database is
+			// live is remembered though
+			counts, commits := countRequests(s, "query")
+			if counts["branches"] != 1 || counts["query"] != 2 {
+				t.Errorf("got requests %v, want 1 for branches and 2 for query", counts)
+			}
+			for _, j := range commits {
+				if j != "" {
+					t.Errorf("live database queried at commit %q", j)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		branches string
+	}{
+		{"server error", 500, `{"error":"database unavailable"}`},
+		{"unknown default branch", 200, `{"branches":{"main":{"commit":"c1"}},"default_branch":"other"}`},
+		{"not live status", 400, `{"error":"not a live database","status":"not_live"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newCachedServer()
+			defer s.Close()
+			s.Handle("branches", tt.status, tt.branches)
+			s.Handle("tables", 200, `["a"]`)
+			_, err := c.Tables("me", "db.sqlite", dbhub.Identifier{})
+			if err == nil {
+				t.Fatal("no error returned")
+			}
+			if counts, _ := countRequests(s, "tables"); counts["tables"] != 0 {
+				t.Errorf("tables requested without knowing the commit")
+			}
+		})
+	}
+}
+
+func TestCacheGivenCommit(t *testing.T) {
+	s, c := newCachedServer()
+	defer s.Close()
+	s.Handle("columns", 200, `[{"column_id":0,"name":"id","data_type":"INTEGER","primary_key":1}]`)
+	for i := 0; i < 2; i++ {
+		_, err := c.Columns("me", "db.sqlite", dbhub.Identifier{CommitID: "c1"}, "t")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, _ := countRequests(s, "columns")
+	if counts["branches"] != 0 || counts["columns"] != 1 {
+		t.Errorf("got requests %v, want just one for columns", counts)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	m := dbhub.NewMemoryCache(2)
+	m.Set("a", []byte("1"))
+	m.Set("b", []byte("2"))
+	m.Get("a") // "b" is now the least recently used
+	m.Set("c", []byte("3"))
+
+	tests := []struct {
+		key    string
+		want   string
+		wantOK bool
+	}{
+		{"a", "1", true},
+		{"b", "", false},
+		{"c", "3", true},
+	}
+	for _, tt := range tests {
+		got, ok := m.Get(tt.key)
+		if ok != tt.wantOK || string(got) != tt.want {
+			t.Errorf("Get(%q) = %q, %v, want %q, %v", tt.key, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package dbhub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cloneInfoSuffix is added to the path of a local copy made by Clone(), to give the path of the file recording where
+// it came from
+const cloneInfoSuffix = ".dbhub.json"
+
+// Clone downloads the head of a branch of a database to a local SQLite file, so it can be queried offline.  If branch
+// is empty, the default branch is used.  Where the copy came from, including the ID of its commit, is recorded in a
+// file next to it (the path with ".dbhub.json" added), which Sync() uses to keep the copy up to date.  An existing
+// file at the path is replaced.
+func (c Connection) Clone(dbOwner, dbName, branch, path string) (info CloneInfo, err error) {
+	return c.CloneContext(context.Background(), dbOwner, dbName, branch, path)
+}
+
+// CloneContext is like Clone, but uses the given context for the requests
+func (c Connection) CloneContext(ctx context.Context, dbOwner, dbName, branch, path string) (info CloneInfo, err error) {
+	info = CloneInfo{Owner: dbOwner, Name: dbName, Branch: branch}
+	var commitID string
+	commitID, info.Branch, err = c.branchHead(ctx, info.Owner, info.Name, info.Branch)
+	if err != nil {
+		return
+	}
+	err = c.cloneCommit(ctx, &info, commitID, path)
+	return
+}
+
+// LocalCloneInfo returns where a local copy made by Clone() came from, and the commit it's a copy of
+func LocalCloneInfo(path string) (info CloneInfo, err error) {
+	b, err := ioutil.ReadFile(path + cloneInfoSuffix)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &info)
+	if err != nil {
+		err = fmt.Errorf("reading clone information for '%s': %w", path, err)
+	}
+	return
+}
+
+// Sync brings a local copy made by Clone() up to date with its branch.  The database is only downloaded again when
+// the head of the branch has moved to a different commit, in which case changed is true.
+func (c Connection) Sync(path string) (info CloneInfo, changed bool, err error) {
+	return c.SyncContext(context.Background(), path)
+}
+
+// SyncContext is like Sync, but uses the given context for the requests
+func (c Connection) SyncContext(ctx context.Context, path string) (info CloneInfo, changed bool, err error) {
+	info, err = LocalCloneInfo(path)
+	if err != nil {
+		return
+	}
+	var commitID string
+	commitID, _, err = c.branchHead(ctx, info.Owner, info.Name, info.Branch)
+	if err != nil {
+		return
+	}
+	if commitID == info.CommitID {
+		return
+	}
+	err = c.cloneCommit(ctx, &info, commitID, path)
+	if err != nil {
+		return
+	}
+	changed = true
+	return
+}
+
+// cloneCommit downloads a commit of a database to a local file, then records where it came from.  The database is
+// written to a temporary file first, so an interrupted download doesn't leave a broken copy behind.
+func (c Connection) cloneCommit(ctx context.Context, info *CloneInfo, commitID, path string) (err error) {
+	db, err := c.DownloadContext(ctx, info.Owner, info.Name, Identifier{CommitID: commitID})
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = io.Copy(tmp, db)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return
+	}
+
+	// Record where the copy came from
+	info.CommitID = commitID
+	info.Synced = time.Now().UTC()
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(path+cloneInfoSuffix, b, 0644)
+	return
+}
@@ -0,0 +1,375 @@
+// Command dbhub is a command line tool for working with databases on DBHub.io.
+//
+// Usage:
+//
+//	dbhub [-apikey key] [-server url] [-config file] <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	query [-format f] owner/database "SQL"   Run a SQL query (SELECT only) on a database
+//	tables [-format f] owner/database        List the tables in a database
+//	commits [-format f] owner/database       List the commits of a database, newest first
+//	download owner/database [file]           Download a database, to the given file or the database name
+//	upload [-message m] file [name]          Upload a database file to your account
+//
+// The output format (-format) can be "table" (the default), "csv", or "json".
+//
+// The API key is taken from the -apikey flag, the DBHUB_API_KEY environment variable, or the config file, in that
+// order.  The config file (by default "dbhub/config" in the user config directory, eg ~/.config/dbhub/config) holds
+// "key = value" lines, with "apikey" and "server" as the recognised keys.  Lines starting with "#" are ignored.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sqlitebrowser/go-dbhub"
+)
+
+// errUsage is returned when the command line can't be understood.  The usage message has already been shown by then
+var errUsage = errors.New("invalid command line")
+
+// config holds the settings read from the config file
+type config struct {
+	APIKey string
+	Server string
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err == errUsage {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dbhub:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the global flags, then runs the chosen command
+func run(args []string, out io.Writer) (err error) {
+	fs := flag.NewFlagSet("dbhub", flag.ContinueOnError)
+	apiKey := fs.String("apikey", "", "DBHub.io API key (default from $DBHUB_API_KEY or the config file)")
+	server := fs.String("server", "", "address of the DBHub.io API server")
+	configFile := fs.String("config", "", "path of the config file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dbhub [flags] <query|tables|commits|download|upload> [arguments]")
+		fs.PrintDefaults()
+	}
+	if fs.Parse(args) != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	// Work out the settings to use
+	cfg, err := readConfig(*configFile)
+	if err != nil {
+		return
+	}
+	key := *apiKey
+	if key == "" {
+		key = os.Getenv("DBHUB_API_KEY")
+	}
+	if key == "" {
+		key = cfg.APIKey
+	}
+	if key == "" {
+		return fmt.Errorf("no API key given.  Use -apikey, set DBHUB_API_KEY, or add it to the config file")
+	}
+	db, err := dbhub.New(key)
+	if err != nil {
+		return
+	}
+	if *server != "" {
+		db.ChangeServer(*server)
+	} else if cfg.Server != "" {
+		db.ChangeServer(cfg.Server)
+	}
+
+	// Run the command
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "query":
+		return cmdQuery(db, cmdArgs, out)
+	case "tables":
+		return cmdTables(db, cmdArgs, out)
+	case "commits":
+		return cmdCommits(db, cmdArgs, out)
+	case "download":
+		return cmdDownload(db, cmdArgs, out)
+	case "upload":
+		return cmdUpload(db, cmdArgs, out)
+	}
+	fmt.Fprintf(fs.Output(), "dbhub: unknown command '%s'\n", cmd)
+	fs.Usage()
+	return errUsage
+}
+
+// cmdCommits lists the commits of a database, newest first
+func cmdCommits(db dbhub.Connection, args []string, out io.Writer) (err error) {
+	fs, format := outputFlags("commits", "owner/database")
+	dbOwner, dbName, err := parseCommandLine(fs, args, 1)
+	if err != nil {
+		return
+	}
+	commits, err := db.Commits(dbOwner, dbName)
+	if err != nil {
+		return
+	}
+
+	// Sort the commits by time, newest first
+	ids := make([]string, 0, len(commits))
+	for id := range commits {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return commits[ids[i]].Timestamp.After(commits[ids[j]].Timestamp) })
+	res := dbhub.Results{ColNames: []string{"commit", "timestamp", "author", "message"}}
+	for _, id := range ids {
+		j := commits[id]
+		res.Rows = append(res.Rows, dbhub.ResultRow{Fields: []string{id, j.Timestamp.Format("2006-01-02 15:04:05"),
+			j.AuthorName, j.Message}})
+	}
+	return writeResults(res, *format, out)
+}
+
+// cmdDownload downloads a database to a local file
+func cmdDownload(db dbhub.Connection, args []string, out io.Writer) (err error) {
+	fs := commandFlags("download", "owner/database [file]")
+	if fs.Parse(args) != nil {
+		return errUsage
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errUsage
+	}
+	dbOwner, dbName, err := splitDatabase(fs.Arg(0))
+	if err != nil {
+		return
+	}
+	path := fs.Arg(1)
+	if path == "" {
+		// Only the last element of the database name is used, so a name holding a path can't write outside the
+		// current directory
+		path = filepath.Base(dbName)
+		switch path {
+		case "", ".", "..", string(filepath.Separator):
+			err = fmt.Errorf("can't use database name '%s' as a file name.  Give the file to download to", dbName)
+			return
+		}
+	}
+
+	// Download the database
+	r, err := db.Download(dbOwner, dbName, dbhub.Identifier{})
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return
+	}
+	fmt.Fprintf(out, "Downloaded %s/%s to %s\n", dbOwner, dbName, path)
+	return
+}
+
+// cmdQuery runs a SQL query on a database
+func cmdQuery(db dbhub.Connection, args []string, out io.Writer) (err error) {
+	fs, format := outputFlags("query", `owner/database "SQL"`)
+	dbOwner, dbName, err := parseCommandLine(fs, args, 2)
+	if err != nil {
+		return
+	}
+	sql := fs.Arg(1)
+
+	// The CSV and JSON output is streamed, so large results don't need to fit in memory
+	db.DefaultBlobBase64 = true
+	switch *format {
+	case "csv":
+		return db.QueryCSV(dbOwner, dbName, sql, out, dbhub.CSVOptions{Header: true})
+	case "json":
+		return db.QueryJSON(dbOwner, dbName, sql, out)
+	}
+	res, err := db.QueryDefault(dbOwner, dbName, dbhub.Identifier{}, sql)
+	if err != nil {
+		return
+	}
+	return writeResults(res, *format, out)
+}
+
+// cmdTables lists the tables in a database
+func cmdTables(db dbhub.Connection, args []string, out io.Writer) (err error) {
+	fs, format := outputFlags("tables", "owner/database")
+	dbOwner, dbName, err := parseCommandLine(fs, args, 1)
+	if err != nil {
+		return
+	}
+	tables, err := db.Tables(dbOwner, dbName, dbhub.Identifier{})
+	if err != nil {
+		return
+	}
+	res := dbhub.Results{ColNames: []string{"table"}}
+	for _, j := range tables {
+		res.Rows = append(res.Rows, dbhub.ResultRow{Fields: []string{j}})
+	}
+	return writeResults(res, *format, out)
+}
+
+// cmdUpload uploads a local database file to your account
+func cmdUpload(db dbhub.Connection, args []string, out io.Writer) (err error) {
+	fs := commandFlags("upload", "file [name]")
+	message := fs.String("message", "", "commit message for the upload")
+	if fs.Parse(args) != nil {
+		return errUsage
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errUsage
+	}
+	path := fs.Arg(0)
+	dbName := filepath.Base(path)
+	if fs.NArg() == 2 {
+		dbName = fs.Arg(1)
+	}
+	err = dbhub.ValidDatabaseName(dbName)
+	if err != nil {
+		return
+	}
+
+	// Upload the database
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	commitID, err := db.UploadReader(dbName, f, dbhub.UploadInformation{CommitMsg: *message})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(out, "Uploaded %s as %s (commit %s)\n", path, dbName, commitID)
+	return
+}
+
+// commandFlags returns the flag set for a command
+func commandFlags(cmd, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet("dbhub "+cmd, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: dbhub %s [flags] %s\n", cmd, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// outputFlags returns the flag set for a command which outputs results, along with its output format flag
+func outputFlags(cmd, usage string) (fs *flag.FlagSet, format *string) {
+	fs = commandFlags(cmd, usage)
+	format = fs.String("format", "table", `output format: "table", "csv", or "json"`)
+	return
+}
+
+// parseCommandLine parses the flags and arguments of a command taking a database followed by other arguments,
+// returning the owner and name of the database
+func parseCommandLine(fs *flag.FlagSet, args []string, nArgs int) (dbOwner, dbName string, err error) {
+	if fs.Parse(args) != nil {
+		err = errUsage
+		return
+	}
+	if fs.NArg() != nArgs {
+		fs.Usage()
+		err = errUsage
+		return
+	}
+	if f := fs.Lookup("format"); f != nil {
+		switch f.Value.String() {
+		case "table", "csv", "json":
+		default:
+			err = fmt.Errorf("unknown output format '%s'", f.Value)
+			return
+		}
+	}
+	return splitDatabase(fs.Arg(0))
+}
+
+// readConfig reads the settings in the config file.  It's not an error for the default config file to be missing
+func readConfig(path string) (cfg config, err error) {
+	explicit := path != ""
+	if !explicit {
+		dir, dirErr := os.UserConfigDir()
+		if dirErr != nil {
+			return
+		}
+		path = filepath.Join(dir, "dbhub", "config")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+
+	// Read the settings
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			err = fmt.Errorf("%s:%d: expected a 'key = value' line", path, n)
+			return
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "apikey":
+			cfg.APIKey = value
+		case "server":
+			cfg.Server = value
+		default:
+			err = fmt.Errorf("%s:%d: unknown setting '%s'", path, n, strings.TrimSpace(kv[0]))
+			return
+		}
+	}
+	err = s.Err()
+	return
+}
+
+// splitDatabase splits an "owner/database" argument into its owner and database name
+func splitDatabase(arg string) (dbOwner, dbName string, err error) {
+	parts := strings.SplitN(arg, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		err = fmt.Errorf("database '%s' should be given as owner/database", arg)
+		return
+	}
+	return parts[0], parts[1], nil
+}
+
+// writeResults writes results in the chosen output format
+func writeResults(res dbhub.Results, format string, out io.Writer) error {
+	switch format {
+	case "csv":
+		return res.WriteCSV(out, dbhub.CSVOptions{Header: true})
+	case "json":
+		return res.WriteJSON(out)
+	}
+	return res.RenderTable(out)
+}
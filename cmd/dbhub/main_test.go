@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+// tempDir creates a temporary directory, and makes it the working directory until the test finishes
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "dbhub-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// runCommand runs the tool against the test server, with an empty config file, returning what it wrote out
+func runCommand(t *testing.T, s *dbhubtest.Server, args ...string) (out string, err error) {
+	t.Helper()
+	cfg := filepath.Join(tempDir(t), "config")
+	if err = ioutil.WriteFile(cfg, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	err = run(append([]string{"-apikey", "key", "-server", s.URL, "-config", cfg}, args...), &b)
+	return b.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		endpoint string
+		response string
+		want     []string
+	}{
+		{
+			name:     "query table",
+			args:     []string{"query", "me/db.sqlite", "SELECT id, name FROM t"},
+			endpoint: "query",
+			response: `[[{"Name":"id","Type":4,"Value":1},{"Name":"name","Type":3,"Value":"one"}]]`,
+			want:     []string{"id", "name", "one"},
+		},
+		{
+			name:     "query csv",
+			args:     []string{"query", "-format", "csv", "me/db.sqlite", "SELECT id, name FROM t"},
+			endpoint: "query",
+			response: `[[{"Name":"id","Type":4,"Value":1},{"Name":"name","Type":3,"Value":"one"}]]`,
+			want:     []string{"id,name\n1,one\n"},
+		},
+		{
+			name:     "query json",
+			args:     []string{"query", "-format", "json", "me/db.sqlite", "SELECT id FROM t"},
+			endpoint: "query",
+			response: `[[{"Name":"id","Type":4,"Value":1}]]`,
+			want:     []string{`"id":1`},
+		},
+		{
+			name:     "tables",
+			args:     []string{"tables", "-format", "csv", "me/db.sqlite"},
+			endpoint: "tables",
+			response: `["first","second"]`,
+			want:     []string{"table\nfirst\nsecond\n"},
+		},
+		{
+			name:     "commits",
+			args:     []string{"commits", "-format", "csv", "me/db.sqlite"},
+			endpoint: "commits",
+			response: `{"c1":{"id":"c1","author_name":"Jane","message":"First","timestamp":"2020-01-02T03:04:05Z"}}`,
+			want:     []string{"c1,2020-01-02 03:04:05,Jane,First"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle(tt.endpoint, 200, tt.response)
+			out, err := runCommand(t, s, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, j := range tt.want {
+				if !strings.Contains(out, j) {
+					t.Errorf("output %q doesn't contain %q", out, j)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandLineErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no command", nil},
+		{"unknown command", []string{"frobnicate"}},
+		{"missing SQL", []string{"query", "me/db.sqlite"}},
+		{"unknown flag", []string{"tables", "-bogus", "me/db.sqlite"}},
+		{"too many download arguments", []string{"download", "me/db.sqlite", "a", "b"}},
+	}
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runCommand(t, s, tt.args...)
+			if err != errUsage {
+				t.Errorf("got error %v, want errUsage", err)
+			}
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantFile string
+		wantErr  bool
+	}{
+		{"default file", []string{"download", "me/db.sqlite"}, "db.sqlite", false},
+		{"given file", []string{"download", "me/db.sqlite", "copy.sqlite"}, "copy.sqlite", false},
+		{"name holding a path", []string{"download", "me/../../evil.sqlite"}, "evil.sqlite", false},
+		{"dot dot name", []string{"download", "me/.."}, "", true},
+		{"dot name", []string{"download", "me/."}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("download", 200, "SQLite format 3")
+			_, err := runCommand(t, s, tt.args...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("no error returned")
+				}
+				if n := len(s.Requests()); n != 0 {
+					t.Errorf("%d requests sent, want none", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(tt.wantFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "SQLite format 3" {
+				t.Errorf("downloaded file holds %q", b)
+			}
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("upload", 201, `{"commit_id":"abc123"}`)
+	dir := tempDir(t)
+	path := filepath.Join(dir, "local.sqlite")
+	if err := ioutil.WriteFile(path, []byte("SQLite format 3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCommand(t, s, "upload", "-message", "New data", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "commit abc123") {
+		t.Errorf("output is %q", out)
+	}
+	reqs := s.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+	if got := reqs[0].Form.Get("dbname"); got != "local.sqlite" {
+		t.Errorf("uploaded as %q, want local.sqlite", got)
+	}
+	if got := reqs[0].Form.Get("commitmsg"); got != "New data" {
+		t.Errorf("commit message is %q", got)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    config
+		wantErr bool
+	}{
+		{"empty", "", config{}, false},
+		{"settings", "# comment\napikey = abc\n\nserver = https://example.com\n", config{APIKey: "abc", Server: "https://example.com"}, false},
+		{"unknown setting", "colour = blue\n", config{}, true},
+		{"not key value", "apikey\n", config{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "config")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
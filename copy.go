@@ -0,0 +1,53 @@
+package dbhub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Copy duplicates a database (the head of its default branch) into your account, under the name destName.  The source
+// database can be one of yours, or a public database of another user.  If your account already has a database called
+// destName, an error wrapping ErrDatabaseExists is returned.
+func (c Connection) Copy(srcOwner, srcName, destName string) (err error) {
+	return c.CopyContext(context.Background(), srcOwner, srcName, destName)
+}
+
+// CopyContext is like Copy, but uses the given context for the requests
+func (c Connection) CopyContext(ctx context.Context, srcOwner, srcName, destName string) (err error) {
+	err = ValidDatabaseName(destName)
+	if err != nil {
+		return
+	}
+
+	// Check the destination doesn't already exist before downloading anything.  The upload only creates databases
+	// (it's sent without a parent commit, which the server requires for adding to an existing one), so a database
+	// created by someone else after this check is still caught by the server rather than being overwritten
+	databases, err := c.DatabasesContext(ctx)
+	if err != nil {
+		return
+	}
+	for _, j := range databases {
+		if j == destName {
+			err = fmt.Errorf("%w: '%s'", ErrDatabaseExists, destName)
+			return
+		}
+	}
+
+	// Stream the source database straight into the new one
+	db, err := c.DownloadContext(ctx, srcOwner, srcName, Identifier{})
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	_, err = c.UploadReaderContext(ctx, destName, db, UploadInformation{
+		CommitMsg: fmt.Sprintf("Copied from %s/%s", srcOwner, srcName),
+	})
+	var e *APIError
+	if errors.As(err, &e) && e.err == nil && e.Code == http.StatusForbidden && strings.Contains(e.Message, "already exists") {
+		e.err = ErrDatabaseExists
+	}
+	return
+}
@@ -3,26 +3,44 @@ package dbhub
 // A Go library for working with databases on DBHub.io
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	com "github.com/sqlitebrowser/dbhub.io/common"
 )
 
 const (
+	// defaultAPIPath is the path the API end points are under on the standard DBHub.io server
+	defaultAPIPath = "/v1"
+
+	// defaultTimeout is the time limit for requests made with the HTTP client of connections created by New()
+	defaultTimeout = 5 * time.Minute
+
 	version = "0.0.2"
 )
 
+// Make sure Connection stays usable as a DBHubAPI
+var _ DBHubAPI = Connection{}
+
 // New creates a new DBHub.io connection object.  It doesn't connect to DBHub.io to do this.  Connection only occurs
 // when subsequent functions (eg Query()) are called.
 func New(key string) (Connection, error) {
 	c := Connection{
-		APIKey: key,
-		Server: "https://api.dbhub.io",
+		APIKey:     key,
+		Server:     "https://api.dbhub.io",
+		APIPath:    defaultAPIPath,
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
 	}
 	return c, nil
 }
@@ -37,74 +55,268 @@ func (c *Connection) ChangeServer(s string) {
 	c.Server = s
 }
 
+// SetAPIPath changes the path the API end points are under on the server (normally "/v1").  Useful with ChangeServer()
+// for self hosted servers which mount the API under a different path.
+func (c *Connection) SetAPIPath(p string) {
+	c.APIPath = p
+}
+
+// SetHTTPClient changes the HTTP client used for communicating with DBHub.io.  Useful for setting a different timeout,
+// using a proxy, trusting the certificate of a self hosted server, or wrapping the transport for tracing (eg with
+// otelhttp.NewTransport()).
+func (c *Connection) SetHTTPClient(client *http.Client) {
+	c.HTTPClient = client
+}
+
+// AccessLevel returns what the API key of the connection is allowed to do with a database.  Servers without the
+// permissions end point are handled by probing instead, in which case AccessRead is the most which can be determined.
+func (c Connection) AccessLevel(dbOwner, dbName string) (level AccessLevel, err error) {
+	return c.AccessLevelContext(context.Background(), dbOwner, dbName)
+}
+
+// AccessLevelContext is like AccessLevel, but uses the given context for the request
+func (c Connection) AccessLevelContext(ctx context.Context, dbOwner, dbName string) (level AccessLevel, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Ask the server for the access level
+	var response struct {
+		Access AccessLevel `json:"access"`
+	}
+	queryUrl := c.apiURL("permissions")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &response)
+	var apiErr *APIError
+	if err == nil {
+		switch response.Access {
+		case AccessNone, AccessOwner, AccessRead, AccessWrite:
+			level = response.Access
+		default:
+			err = fmt.Errorf("unknown access level '%s'", response.Access)
+		}
+		return
+	}
+	if !errors.As(err, &apiErr) || (apiErr.Code != http.StatusNotFound && apiErr.Code != http.StatusNotImplemented) {
+		return
+	}
+
+	// The server doesn't have the permissions end point, so see whether the database can be read instead
+	_, err = c.MetadataContext(ctx, dbOwner, dbName)
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			level = AccessNone
+			err = nil
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	level = AccessRead
+	return
+}
+
 // Branches returns a list of all available branches of a database along with the name of the default branch
 func (c Connection) Branches(dbOwner, dbName string) (branches map[string]com.BranchEntry, defaultBranch string, err error) {
+	return c.BranchesContext(context.Background(), dbOwner, dbName)
+}
+
+// BranchesContext is like Branches, but uses the given context for the request
+func (c Connection) BranchesContext(ctx context.Context, dbOwner, dbName string) (branches map[string]com.BranchEntry, defaultBranch string, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, Identifier{})
 
 	// Fetch the list of branches and the default branch
 	var response com.BranchListResponseContainer
-	queryUrl := c.Server + "/v1/branches"
-	err = sendRequestJSON(queryUrl, data, &response)
+	queryUrl := c.apiURL("branches")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &response)
+	if err != nil {
+		return
+	}
 
 	// Extract information for return values
 	branches = response.Branches
 	defaultBranch = response.DefaultBranch
+	c.rememberHeads(dbOwner, dbName, branches, defaultBranch)
+	return
+}
+
+// BuildRequest returns the request which would be sent to the given API end point (eg "query") with the given
+// parameters, without sending it.  The API key in the request is replaced by "REDACTED", so the request is safe to log.
+// It's useful for auditing and testing.  The parameters can be created with PrepareVals().
+func (c Connection) BuildRequest(endpoint string, params url.Values) (req *http.Request, err error) {
+	data := url.Values{}
+	for k, v := range params {
+		data[k] = append([]string(nil), v...)
+	}
+	if data.Get("apikey") != "" {
+		data.Set("apikey", "REDACTED")
+	}
+	queryUrl := c.apiURL(endpoint)
+	req, err = newRequest(context.Background(), queryUrl, data, "")
 	return
 }
 
 // Columns returns the column information for a given table or view
 func (c Connection) Columns(dbOwner, dbName string, ident Identifier, table string) (columns []com.APIJSONColumn, err error) {
+	return c.ColumnsContext(context.Background(), dbOwner, dbName, ident, table)
+}
+
+// ColumnsContext is like Columns, but uses the given context for the request
+func (c Connection) ColumnsContext(ctx context.Context, dbOwner, dbName string, ident Identifier, table string) (columns []com.APIJSONColumn, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, ident)
 	data.Set("table", table)
 
 	// Fetch the list of columns
-	queryUrl := c.Server + "/v1/columns"
-	err = sendRequestJSON(queryUrl, data, &columns)
+	queryUrl := c.apiURL("columns")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &columns)
 	return
 }
 
 // Commits returns the details of all commits for a database
 func (c Connection) Commits(dbOwner, dbName string) (commits map[string]com.CommitEntry, err error) {
+	return c.CommitsContext(context.Background(), dbOwner, dbName)
+}
+
+// CommitsContext is like Commits, but uses the given context for the request
+func (c Connection) CommitsContext(ctx context.Context, dbOwner, dbName string) (commits map[string]com.CommitEntry, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, Identifier{})
 
 	// Fetch the commits
-	queryUrl := c.Server + "/v1/commits"
-	err = sendRequestJSON(queryUrl, data, &commits)
+	queryUrl := c.apiURL("commits")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &commits)
 	return
 }
 
-// Databases returns the list of databases in your account
+// CreateWebhook registers a webhook for a database.  When one of the given events occurs, the DBHub.io server sends a
+// POST request to the target URL.  The ID of the new webhook is returned, for use with DeleteWebhook().  Use
+// Webhooks() to list the existing ones
+func (c Connection) CreateWebhook(dbOwner, dbName, targetURL string, events []string) (id string, err error) {
+	return c.CreateWebhookContext(context.Background(), dbOwner, dbName, targetURL, events)
+}
+
+// CreateWebhookContext is like CreateWebhook, but uses the given context for the request
+func (c Connection) CreateWebhookContext(ctx context.Context, dbOwner, dbName, targetURL string, events []string) (id string, err error) {
+	// Make sure the target URL is something the server can actually send requests to
+	err = validateWebhookURL(targetURL)
+	if err != nil {
+		return
+	}
+
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("url", targetURL)
+	for _, j := range events {
+		data.Add("event", j)
+	}
+
+	// Create the webhook
+	var response struct {
+		ID string `json:"id"`
+	}
+	queryUrl := c.apiURL("createwebhook")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &response)
+	if err != nil {
+		return
+	}
+	id = response.ID
+	return
+}
+
+// DatabaseLicence returns the details of the licence currently assigned to a database
+func (c Connection) DatabaseLicence(dbOwner, dbName string) (licence com.LicenceEntry, err error) {
+	return c.DatabaseLicenceContext(context.Background(), dbOwner, dbName)
+}
+
+// DatabaseLicenceContext is like DatabaseLicence, but uses the given context for the request
+func (c Connection) DatabaseLicenceContext(ctx context.Context, dbOwner, dbName string) (licence com.LicenceEntry, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Fetch the licence details
+	queryUrl := c.apiURL("licence")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &licence)
+	return
+}
+
+// Databases returns the list of standard (non-live) databases in your account.  Use LiveDatabases() for the live ones,
+// and DatabasesForUser() for the public databases of another user
 func (c Connection) Databases() (databases []string, err error) {
+	return c.DatabasesContext(context.Background())
+}
+
+// DatabasesContext is like Databases(), but the request is bound to the given context
+func (c Connection) DatabasesContext(ctx context.Context) (databases []string, err error) {
+	return c.databaseNames(ctx, "")
+}
+
+// databaseNames returns the names of the standard databases owned by a user, or those in your account if dbOwner is
+// empty.  Only the public databases of other users are listed
+func (c Connection) databaseNames(ctx context.Context, dbOwner string) (names []string, err error) {
 	// Prepare the API parameters
 	data := url.Values{}
 	data.Set("apikey", c.APIKey)
+	if dbOwner != "" {
+		data.Set("dbowner", dbOwner)
+	}
 
 	// Fetch the list of databases
-	queryUrl := c.Server + "/v1/databases"
-	err = sendRequestJSON(queryUrl, data, &databases)
+	queryUrl := c.apiURL("databases")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &names)
 	return
 }
 
-// Delete deletes a database in your account
+// Delete deletes a database in your account.  If the connection has a ConfirmDestructive check, it's run first.
 func (c Connection) Delete(dbName string) (err error) {
+	return c.DeleteContext(context.Background(), dbName)
+}
+
+// DeleteContext is like Delete, but uses the given context for the request
+func (c Connection) DeleteContext(ctx context.Context, dbName string) (err error) {
+	err = c.confirm("delete", dbName)
+	if err != nil {
+		return
+	}
+
 	// Prepare the API parameters
 	data := c.PrepareVals("", dbName, Identifier{})
 
 	// Delete the database
-	queryUrl := c.Server + "/v1/delete"
-	err = sendRequestJSON(queryUrl, data, nil)
+	queryUrl := c.apiURL("delete")
+	err = c.sendRequestJSON(ctx, queryUrl, data, nil)
 	if err != nil && err.Error() == "no rows in result set" { // Feels like a dodgy workaround
 		err = fmt.Errorf("Unknown database\n")
 	}
 	return
 }
 
+// DeleteWebhook removes a webhook from a database
+func (c Connection) DeleteWebhook(dbOwner, dbName, id string) (err error) {
+	return c.DeleteWebhookContext(context.Background(), dbOwner, dbName, id)
+}
+
+// DeleteWebhookContext is like DeleteWebhook, but uses the given context for the request
+func (c Connection) DeleteWebhookContext(ctx context.Context, dbOwner, dbName, id string) (err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("id", id)
+
+	// Delete the webhook
+	queryUrl := c.apiURL("deletewebhook")
+	err = c.sendRequestJSON(ctx, queryUrl, data, nil)
+	return
+}
+
 // Diff returns the differences between two commits of two databases, or if the details on the second database are left empty,
 // between two commits of the same database. You can also specify the merge strategy used for the generated SQL statements.
 func (c Connection) Diff(dbOwnerA, dbNameA string, identA Identifier, dbOwnerB, dbNameB string, identB Identifier, merge MergeStrategy) (diffs com.Diffs, err error) {
+	return c.DiffContext(context.Background(), dbOwnerA, dbNameA, identA, dbOwnerB, dbNameB, identB, merge)
+}
+
+// DiffContext is like Diff, but uses the given context for the request
+func (c Connection) DiffContext(ctx context.Context, dbOwnerA, dbNameA string, identA Identifier, dbOwnerB, dbNameB string, identB Identifier, merge MergeStrategy) (diffs com.Diffs, err error) {
 	// Prepare the API parameters
 	data := url.Values{}
 	data.Set("apikey", c.APIKey)
@@ -145,44 +357,242 @@ func (c Connection) Diff(dbOwnerA, dbNameA string, identA Identifier, dbOwnerB,
 	}
 
 	// Fetch the diffs
-	queryUrl := c.Server + "/v1/diff"
-	err = sendRequestJSON(queryUrl, data, &diffs)
+	queryUrl := c.apiURL("diff")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &diffs)
 	return
 }
 
 // Download returns the database file
 func (c Connection) Download(dbOwner, dbName string, ident Identifier) (db io.ReadCloser, err error) {
+	return c.DownloadContext(context.Background(), dbOwner, dbName, ident)
+}
+
+// DownloadContext is like Download(), but the request is bound to the given context
+func (c Connection) DownloadContext(ctx context.Context, dbOwner, dbName string, ident Identifier) (db io.ReadCloser, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, ident)
 
 	// Fetch the database file
-	queryUrl := c.Server + "/v1/download"
-	db, err = sendRequest(queryUrl, data)
+	queryUrl := c.apiURL("download")
+	db, err = c.sendRequest(ctx, queryUrl, data)
 	if err != nil {
 		return
 	}
 	return
 }
 
+// Execute runs a SQL statement (INSERT, UPDATE, DELETE, etc) on a live database, returning the number of rows changed.
+// SQL errors reported by the server are returned as an *APIError, and trying to change a standard database returns an
+// error wrapping ErrNotLiveDatabase.  Use ExecuteResult() to also get the rowid of the last row inserted.
+func (c Connection) Execute(dbOwner, dbName, sql string) (rowsChanged int64, err error) {
+	return c.ExecuteContext(context.Background(), dbOwner, dbName, sql)
+}
+
+// ExecuteContext is like Execute(), but the request is bound to the given context.  Use WithIdempotencyKey() on the
+// context to allow the statement to be retried
+func (c Connection) ExecuteContext(ctx context.Context, dbOwner, dbName, sql string) (rowsChanged int64, err error) {
+	var res ExecResult
+	res, err = c.ExecuteResultContext(ctx, dbOwner, dbName, sql)
+	rowsChanged = res.RowsChanged
+	return
+}
+
+// ExecuteResult runs a SQL statement on a live database, returning the number of rows changed along with the rowid of
+// the last row inserted.  Trying to change a standard database returns an error wrapping ErrNotLiveDatabase.
+func (c Connection) ExecuteResult(dbOwner, dbName, sql string) (result ExecResult, err error) {
+	return c.ExecuteResultContext(context.Background(), dbOwner, dbName, sql)
+}
+
+// ExecuteResultContext is like ExecuteResult(), but the request is bound to the given context
+func (c Connection) ExecuteResultContext(ctx context.Context, dbOwner, dbName, sql string) (result ExecResult, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
+
+	// Run the statement on the remote database
+	var response struct {
+		RowsChanged  int64  `json:"rows_changed"`
+		LastInsertID int64  `json:"last_insert_id"`
+		Status       string `json:"status"`
+		Error        string `json:"error"`
+	}
+	queryUrl := c.apiURL("execute")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &response)
+	if err != nil {
+		return
+	}
+
+	// Some SQL errors are reported in the body of an otherwise successful response, so make sure they aren't missed.
+	// The server refuses to run statements on standard databases (as they can only be changed by uploading a new
+	// commit), which it reports with a "not_live" status
+	if response.Status == "not_live" {
+		msg := response.Error
+		if msg == "" {
+			msg = ErrNotLiveDatabase.Error()
+		}
+		err = &APIError{Code: http.StatusOK, Endpoint: "execute", Message: msg, err: ErrNotLiveDatabase}
+		return
+	}
+	if response.Error != "" {
+		err = &APIError{Code: http.StatusOK, Endpoint: "execute", Message: response.Error}
+		return
+	}
+	if response.Status != "" && !strings.EqualFold(response.Status, "ok") {
+		err = &APIError{Code: http.StatusOK, Endpoint: "execute", Message: response.Status}
+		return
+	}
+	result = ExecResult{SQL: sql, RowsChanged: response.RowsChanged, LastInsertID: response.LastInsertID}
+	return
+}
+
 // Indexes returns the list of indexes present in the database, along with the table they belong to
 func (c Connection) Indexes(dbOwner, dbName string, ident Identifier) (idx []com.APIJSONIndex, err error) {
+	return c.IndexesContext(context.Background(), dbOwner, dbName, ident)
+}
+
+// IndexesContext is like Indexes, but uses the given context for the request
+func (c Connection) IndexesContext(ctx context.Context, dbOwner, dbName string, ident Identifier) (idx []com.APIJSONIndex, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, ident)
 
 	// Fetch the list of indexes
-	queryUrl := c.Server + "/v1/indexes"
-	err = sendRequestJSON(queryUrl, data, &idx)
+	queryUrl := c.apiURL("indexes")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &idx)
+	return
+}
+
+// Labels returns the labels (arbitrary key/value pairs) attached to a database
+func (c Connection) Labels(dbOwner, dbName string) (labels map[string]string, err error) {
+	return c.LabelsContext(context.Background(), dbOwner, dbName)
+}
+
+// LabelsContext is like Labels, but uses the given context for the request
+func (c Connection) LabelsContext(ctx context.Context, dbOwner, dbName string) (labels map[string]string, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Fetch the labels
+	queryUrl := c.apiURL("labels")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &labels)
+	return
+}
+
+// LiveDatabases returns the list of live databases in your account.  Live databases are kept on the server as SQLite
+// files which can be changed directly with Execute(), rather than being versioned through uploaded commits.
+func (c Connection) LiveDatabases() (databases []string, err error) {
+	return c.LiveDatabasesContext(context.Background())
+}
+
+// LiveDatabasesContext is like LiveDatabases, but uses the given context for the request
+func (c Connection) LiveDatabasesContext(ctx context.Context) (databases []string, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals("", "", Identifier{})
+	data.Set("live", "true")
+
+	// Fetch the list of live databases
+	queryUrl := c.apiURL("databases")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &databases)
+	return
+}
+
+// LiveLockStatus returns whether a live database is currently locked, and if so, the name of the lock holder
+func (c Connection) LiveLockStatus(dbOwner, dbName string) (locked bool, holder string, err error) {
+	return c.LiveLockStatusContext(context.Background(), dbOwner, dbName)
+}
+
+// LiveLockStatusContext is like LiveLockStatus, but uses the given context for the request
+func (c Connection) LiveLockStatusContext(ctx context.Context, dbOwner, dbName string) (locked bool, holder string, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Fetch the lock status
+	var response struct {
+		Locked bool   `json:"locked"`
+		Holder string `json:"holder"`
+	}
+	queryUrl := c.apiURL("lockstatus")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &response)
+	if err != nil {
+		return
+	}
+	locked = response.Locked
+	holder = response.Holder
+	return
+}
+
+// Lock acquires an exclusive lock on a live database, so a series of changes can be made without other writers getting
+// in between.  The returned function releases the lock.  It only contacts the server the first time it's called, with
+// later calls returning the same result.  If the database is already locked, the error returned wraps
+// ErrDatabaseLocked.
+func (c Connection) Lock(dbOwner, dbName string) (unlock func() error, err error) {
+	return c.LockContext(context.Background(), dbOwner, dbName)
+}
+
+// LockContext is like Lock, but uses the given context for acquiring the lock.  Releasing the lock keeps the values of
+// the context (eg for the OnRequest hook), but not its deadline or cancellation, so it still works after the context
+// has ended.
+func (c Connection) LockContext(ctx context.Context, dbOwner, dbName string) (unlock func() error, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Acquire the lock
+	var resp *http.Response
+	queryUrl := c.apiURL("lock")
+	resp, err = c.doRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict, http.StatusLocked:
+		e := responseError(resp)
+		e.err = ErrDatabaseLocked
+		err = e
+		return
+	default:
+		err = responseError(resp)
+		return
+	}
+	var response struct {
+		LockID string `json:"lock_id"`
+	}
+	_, err = decodeResponse(queryUrl, resp.Body, &response)
+	if err != nil {
+		return
+	}
+
+	// Create the function for releasing the lock
+	var once sync.Once
+	var unlockErr error
+	unlock = func() error {
+		once.Do(func() {
+			d := c.PrepareVals(dbOwner, dbName, Identifier{})
+			d.Set("lock_id", response.LockID)
+			unlockErr = c.sendRequestJSON(unlockContext{ctx}, c.apiURL("unlock"), d, nil)
+		})
+		return unlockErr
+	}
 	return
 }
 
 // Metadata returns the metadata (branches, releases, tags, commits, etc) for the database
 func (c Connection) Metadata(dbOwner, dbName string) (meta com.MetadataResponseContainer, err error) {
+	return c.MetadataContext(context.Background(), dbOwner, dbName)
+}
+
+// MetadataContext is like Metadata, but uses the given context for the request
+func (c Connection) MetadataContext(ctx context.Context, dbOwner, dbName string) (meta com.MetadataResponseContainer, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, Identifier{})
 
 	// Fetch the list of databases
-	queryUrl := c.Server + "/v1/metadata"
-	err = sendRequestJSON(queryUrl, data, &meta)
+	queryUrl := c.apiURL("metadata")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &meta)
+	if err != nil {
+		return
+	}
+	c.rememberHeads(dbOwner, dbName, meta.Branches, meta.DefBranch)
 	return
 }
 
@@ -219,98 +629,298 @@ func (c Connection) PrepareVals(dbOwner, dbName string, ident Identifier) (data
 // The "blobBase64" boolean specifies whether BLOB data fields should be base64 encoded in the output, or just skipped
 // using an empty string as a placeholder.
 func (c Connection) Query(dbOwner, dbName string, ident Identifier, blobBase64 bool, sql string) (out Results, err error) {
+	return c.QueryContext(context.Background(), dbOwner, dbName, ident, blobBase64, sql)
+}
+
+// QueryContext is like Query(), but the request is bound to the given context
+func (c Connection) QueryContext(ctx context.Context, dbOwner, dbName string, ident Identifier, blobBase64 bool, sql string) (out Results, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, ident)
 	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
 
 	// Run the query on the remote database
-	var returnedData []com.DataRow
-	queryUrl := c.Server + "/v1/query"
-	err = sendRequestJSON(queryUrl, data, &returnedData)
+	out, err = c.query(ctx, data, blobBase64)
+	return
+}
+
+// QueryDefault runs a SQL query (SELECT only) on the chosen database, returning the results.  BLOB fields are handled
+// as given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) QueryDefault(dbOwner, dbName string, ident Identifier, sql string) (out Results, err error) {
+	return c.QueryDefaultContext(context.Background(), dbOwner, dbName, ident, sql)
+}
+
+// QueryDefaultContext is like QueryDefault, but uses the given context for the request
+func (c Connection) QueryDefaultContext(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string) (out Results, err error) {
+	return c.QueryContext(ctx, dbOwner, dbName, ident, c.DefaultBlobBase64, sql)
+}
+
+// QueryResponse runs a SQL query (SELECT only) on the chosen database, returning the raw HTTP response from the server.
+// It's an escape hatch for streaming the results or inspecting the response headers directly.  The status code of
+// the response isn't checked and server side errors aren't decoded, so handling those is up to the caller, who is
+// also responsible for closing the response body.
+func (c Connection) QueryResponse(ctx context.Context, dbOwner, dbName, sql string) (resp *http.Response, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
+
+	// Send the query to the remote database
+	queryUrl := c.apiURL("query")
+	resp, err = c.doRequest(ctx, queryUrl, data)
+	return
+}
+
+// Releases returns the details of all releases for a database
+func (c Connection) Releases(dbOwner, dbName string) (releases map[string]com.ReleaseEntry, err error) {
+	return c.ReleasesContext(context.Background(), dbOwner, dbName)
+}
+
+// ReleasesContext is like Releases, but uses the given context for the request
+func (c Connection) ReleasesContext(ctx context.Context, dbOwner, dbName string) (releases map[string]com.ReleaseEntry, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Fetch the releases
+	queryUrl := c.apiURL("releases")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &releases)
+	return
+}
+
+// Rename changes the name of a database in your account.  If the connection has a ConfirmDestructive check, it's run
+// first.  If there's already a database with the new name, the error returned wraps ErrDatabaseExists.
+func (c Connection) Rename(dbName, newName string) (err error) {
+	return c.RenameContext(context.Background(), dbName, newName)
+}
+
+// RenameContext is like Rename, but uses the given context for the request
+func (c Connection) RenameContext(ctx context.Context, dbName, newName string) (err error) {
+	if newName == "" {
+		err = fmt.Errorf("no new database name given")
+		return
+	}
+	err = c.confirm("rename", dbName)
 	if err != nil {
 		return
 	}
 
-	// Loop through the results, converting it to a more concise output format
-	for _, j := range returnedData {
-
-		// Construct a single row
-		var oneRow ResultRow
-		for _, l := range j {
-			switch l.Type {
-			case com.Float, com.Integer, com.Text:
-				// Float, integer, and text fields are added to the output
-				oneRow.Fields = append(oneRow.Fields, fmt.Sprint(l.Value))
-			case com.Binary:
-				// BLOB data is optionally Base64 encoded, or just skipped (using an empty string as placeholder)
-				if blobBase64 {
-					// Safety check. Make sure we've received a string
-					if s, ok := l.Value.(string); ok {
-						oneRow.Fields = append(oneRow.Fields, base64.StdEncoding.EncodeToString([]byte(s)))
-					} else {
-						oneRow.Fields = append(oneRow.Fields, fmt.Sprintf("unexpected data type '%T' for returned BLOB", l.Value))
-					}
-				} else {
-					oneRow.Fields = append(oneRow.Fields, "")
-				}
-			default:
-				// All other value types are just output as an empty string (for now)
-				oneRow.Fields = append(oneRow.Fields, "")
-			}
+	// Prepare the API parameters
+	data := c.PrepareVals("", dbName, Identifier{})
+	data.Set("newname", newName)
+
+	// Rename the database
+	queryUrl := c.apiURL("rename")
+	err = c.sendRequestJSON(ctx, queryUrl, data, nil)
+	var e *APIError
+	if errors.As(err, &e) && e.err == nil && e.Code == http.StatusConflict {
+		e.err = ErrDatabaseExists
+	}
+	return
+}
+
+// ServerTime returns the current time according to the DBHub.io server, along with how far ahead of the local clock
+// it is (negative if behind).  The server time has a resolution of one second, and the skew is measured against the
+// midpoint of the request.
+func (c Connection) ServerTime() (serverTime time.Time, skew time.Duration, err error) {
+	return c.ServerTimeContext(context.Background())
+}
+
+// ServerTimeContext is like ServerTime, but uses the given context for the request
+func (c Connection) ServerTimeContext(ctx context.Context) (serverTime time.Time, skew time.Duration, err error) {
+	// The request goes through the same retries, circuit breaker, and OnRequest hook as API requests.  HEAD requests
+	// don't change anything, so are always safe to retry.  The skew is measured against the attempt which succeeded
+	var resp *http.Response
+	var start time.Time
+	resp, err = c.doRetried(ctx, c.Server, c.Retry.MaxRetries, func(key string) (req *http.Request, err error) {
+		req, err = http.NewRequestWithContext(ctx, http.MethodHead, c.Server, nil)
+		if err != nil {
+			return
 		}
+		req.Header.Set("User-Agent", fmt.Sprintf("go-dbhub v%s", version))
+		start = time.Now()
+		return
+	})
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	end := time.Now()
 
-		// Add the row to the output list
-		out.Rows = append(out.Rows, oneRow)
+	// Extract the server time from the Date header of the response
+	date := resp.Header.Get("Date")
+	if date == "" {
+		err = fmt.Errorf("server response has no Date header")
+		return
+	}
+	serverTime, err = http.ParseTime(date)
+	if err != nil {
+		return
 	}
+	skew = serverTime.Sub(start.Add(end.Sub(start) / 2))
 	return
 }
 
-// Releases returns the details of all releases for a database
-func (c Connection) Releases(dbOwner, dbName string) (releases map[string]com.ReleaseEntry, err error) {
+// SetDatabaseLicence changes the licence assigned to a database.  The licence is given using its short name (eg
+// "CC-BY-SA-4.0"), and must be one known to the server
+func (c Connection) SetDatabaseLicence(dbOwner, dbName, licence string) (err error) {
+	return c.SetDatabaseLicenceContext(context.Background(), dbOwner, dbName, licence)
+}
+
+// SetDatabaseLicenceContext is like SetDatabaseLicence, but uses the given context for the request
+func (c Connection) SetDatabaseLicenceContext(ctx context.Context, dbOwner, dbName, licence string) (err error) {
+	if com.ValidateLicence(licence) != nil {
+		err = fmt.Errorf("invalid licence name '%s'", licence)
+		return
+	}
+
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("licence", licence)
 
-	// Fetch the releases
-	queryUrl := c.Server + "/v1/releases"
-	err = sendRequestJSON(queryUrl, data, &releases)
+	// Change the licence
+	queryUrl := c.apiURL("setlicence")
+	err = c.sendRequestJSON(ctx, queryUrl, data, nil)
+	return
+}
+
+// SetLabel attaches a label (an arbitrary key/value pair) to a database, replacing any existing value for the key
+func (c Connection) SetLabel(dbOwner, dbName, key, value string) (err error) {
+	return c.SetLabelContext(context.Background(), dbOwner, dbName, key, value)
+}
+
+// SetLabelContext is like SetLabel, but uses the given context for the request
+func (c Connection) SetLabelContext(ctx context.Context, dbOwner, dbName, key, value string) (err error) {
+	if key == "" {
+		err = fmt.Errorf("label key is empty")
+		return
+	}
+
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("key", key)
+	data.Set("value", value)
+
+	// Set the label
+	queryUrl := c.apiURL("setlabel")
+	err = c.sendRequestJSON(ctx, queryUrl, data, nil)
 	return
 }
 
 // Tables returns the list of tables in the database
 func (c Connection) Tables(dbOwner, dbName string, ident Identifier) (tbl []string, err error) {
+	return c.TablesContext(context.Background(), dbOwner, dbName, ident)
+}
+
+// TablesContext is like Tables, but uses the given context for the request
+func (c Connection) TablesContext(ctx context.Context, dbOwner, dbName string, ident Identifier) (tbl []string, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, ident)
 
 	// Fetch the list of tables
-	queryUrl := c.Server + "/v1/tables"
-	err = sendRequestJSON(queryUrl, data, &tbl)
+	queryUrl := c.apiURL("tables")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &tbl)
 	return
 }
 
 // Tags returns the details of all tags for a database
 func (c Connection) Tags(dbOwner, dbName string) (tags map[string]com.TagEntry, err error) {
+	return c.TagsContext(context.Background(), dbOwner, dbName)
+}
+
+// TagsContext is like Tags, but uses the given context for the request
+func (c Connection) TagsContext(ctx context.Context, dbOwner, dbName string) (tags map[string]com.TagEntry, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, Identifier{})
 
 	// Fetch the tags
-	queryUrl := c.Server + "/v1/tags"
-	err = sendRequestJSON(queryUrl, data, &tags)
+	queryUrl := c.apiURL("tags")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &tags)
 	return
 }
 
 // Views returns the list of views in the database
 func (c Connection) Views(dbOwner, dbName string, ident Identifier) (views []string, err error) {
+	return c.ViewsContext(context.Background(), dbOwner, dbName, ident)
+}
+
+// ViewsContext is like Views, but uses the given context for the request
+func (c Connection) ViewsContext(ctx context.Context, dbOwner, dbName string, ident Identifier) (views []string, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, ident)
 
 	// Fetch the list of views
-	queryUrl := c.Server + "/v1/views"
-	err = sendRequestJSON(queryUrl, data, &views)
+	queryUrl := c.apiURL("views")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &views)
 	return
 }
 
 // Upload uploads a new database, or a new revision of a database
 func (c Connection) Upload(dbName string, info UploadInformation, dbBytes *[]byte) (err error) {
+	return c.UploadContext(context.Background(), dbName, info, dbBytes)
+}
+
+// UploadContext is like Upload, but uses the given context for the request
+func (c Connection) UploadContext(ctx context.Context, dbName string, info UploadInformation, dbBytes *[]byte) (err error) {
+	_, err = c.upload(ctx, dbName, info, bytes.NewReader(*dbBytes))
+	return
+}
+
+// UploadIfChanged uploads a new revision of a database, but only if it differs from the database at the head of the
+// branch being uploaded to (the default branch, unless info.Ident gives one).  The commit ID of the branch head is
+// returned, along with whether the upload took place.  If the database doesn't exist yet, it's always uploaded.
+func (c Connection) UploadIfChanged(dbName string, info UploadInformation, dbBytes *[]byte) (commitID string, modified bool, err error) {
+	return c.UploadIfChangedContext(context.Background(), dbName, info, dbBytes)
+}
+
+// UploadIfChangedContext is like UploadIfChanged, but uses the given context for the requests
+func (c Connection) UploadIfChangedContext(ctx context.Context, dbName string, info UploadInformation, dbBytes *[]byte) (commitID string, modified bool, err error) {
+	// Compare the hash of the database file with that of the branch head
+	meta, metaErr := c.MetadataContext(ctx, "", dbName)
+	if metaErr == nil {
+		branch := info.Ident.Branch
+		if branch == "" {
+			branch = meta.DefBranch
+		}
+		if head, ok := meta.Branches[branch]; ok {
+			sum := sha256.Sum256(*dbBytes)
+			if commit, ok := meta.Commits[head.Commit]; ok && commitDBHash(commit) == hex.EncodeToString(sum[:]) {
+				commitID = head.Commit
+				return
+			}
+		}
+	}
+
+	// The database has changed, so upload it
+	commitID, err = c.upload(ctx, dbName, info, bytes.NewReader(*dbBytes))
+	if err != nil {
+		return
+	}
+	modified = true
+	return
+}
+
+// UploadReader uploads a new database, or a new revision of a database, reading the database file from db.  The file
+// is streamed to the server rather than being read into memory first.  When adding a revision to an existing database,
+// info.Ident.Branch chooses the branch (the default branch if empty), and info.Ident.CommitID can be set to the commit
+// expected at its head, for the server to reject the upload if someone else has committed since.  The ID of the new
+// commit is returned.
+func (c Connection) UploadReader(dbName string, db io.Reader, info UploadInformation) (commitID string, err error) {
+	return c.UploadReaderContext(context.Background(), dbName, db, info)
+}
+
+// UploadReaderContext is like UploadReader, but uses the given context for the request
+func (c Connection) UploadReaderContext(ctx context.Context, dbName string, db io.Reader, info UploadInformation) (commitID string, err error) {
+	return c.upload(ctx, dbName, info, db)
+}
+
+// confirm runs the ConfirmDestructive check of the connection (if any) for an action on a database
+func (c Connection) confirm(action, dbName string) error {
+	if c.ConfirmDestructive != nil && !c.ConfirmDestructive(action, dbName) {
+		return fmt.Errorf("%s '%s': %w", action, dbName, ErrNotConfirmed)
+	}
+	return nil
+}
+
+// upload uploads a new database, or a new revision of a database, returning the ID of the new commit
+func (c Connection) upload(ctx context.Context, dbName string, info UploadInformation, db io.Reader) (commitID string, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals("", dbName, info.Ident)
 	data.Del("dbowner") // The upload function always stores the database in the account of the API key user
@@ -356,32 +966,107 @@ func (c Connection) Upload(dbName string, info UploadInformation, dbBytes *[]byt
 
 	// Upload the database
 	var body io.ReadCloser
-	queryUrl := c.Server + "/v1/upload"
-	body, err = sendUpload(queryUrl, &data, dbBytes)
-	if body != nil {
-		defer body.Close()
+	queryUrl := c.apiURL("upload")
+	body, err = c.sendUpload(ctx, queryUrl, data, db)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+
+	// Extract the ID of the new commit.  An empty response body isn't an error, it just means the server didn't
+	// provide one
+	var response struct {
+		CommitID string `json:"commit_id"`
+	}
+	_, err = decodeResponse(queryUrl, body, &response)
+	err = contextError(ctx, queryUrl, err)
+	if errors.Is(err, io.EOF) {
+		err = nil
 	}
 	if err != nil {
-		if body != nil {
-			// If there's useful error info in the returned JSON, return that as the error message
-			var z JSONError
-			err = json.NewDecoder(body).Decode(&z)
-			if err != nil {
-				return
-			}
-			err = fmt.Errorf("%s", z.Msg)
-		}
+		return
+	}
+	commitID = response.CommitID
+	return
+}
+
+// UserDatabases returns the list of public databases owned by the given user
+//
+// Deprecated: Use DatabasesForUser(), which also returns the visibility and an overview of each database.
+func (c Connection) UserDatabases(userName string) (databases []com.DBEntry, err error) {
+	return c.UserDatabasesContext(context.Background(), userName)
+}
+
+// UserDatabasesContext is like UserDatabases, but uses the given context for the request
+//
+// Deprecated: Use DatabasesForUserContext().
+func (c Connection) UserDatabasesContext(ctx context.Context, userName string) (databases []com.DBEntry, err error) {
+	names, err := c.databaseNames(ctx, userName)
+	if err != nil {
+		return
+	}
+	for _, j := range names {
+		databases = append(databases, com.DBEntry{DBName: j, Owner: userName})
 	}
 	return
 }
 
 // Webpage returns the URL of the database file in the webUI.  eg. for web browsers
 func (c Connection) Webpage(dbOwner, dbName string) (webPage com.WebpageResponseContainer, err error) {
+	return c.WebpageContext(context.Background(), dbOwner, dbName)
+}
+
+// WebpageContext is like Webpage, but uses the given context for the request
+func (c Connection) WebpageContext(ctx context.Context, dbOwner, dbName string) (webPage com.WebpageResponseContainer, err error) {
 	// Prepare the API parameters
 	data := c.PrepareVals(dbOwner, dbName, Identifier{})
 
 	// Fetch the releases
-	queryUrl := c.Server + "/v1/webpage"
-	err = sendRequestJSON(queryUrl, data, &webPage)
+	queryUrl := c.apiURL("webpage")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &webPage)
 	return
 }
+
+// validateWebhookURL checks that a webhook target is an absolute http or https URL
+func validateWebhookURL(targetURL string) (err error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook URL must use http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("webhook URL is missing a host name")
+	}
+	return
+}
+
+// unlockContext is the context used for releasing a lock taken with LockContext().  It keeps the values of the
+// context the lock was taken with, apart from any idempotency key (which was for the lock request), but never ends.
+type unlockContext struct {
+	context.Context
+}
+
+// Deadline returns no deadline, as releasing a lock isn't bound by the deadline of the context it was taken with
+func (unlockContext) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+// Done returns nil, as releasing a lock isn't cancelled along with the context it was taken with
+func (unlockContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err always returns nil, as the context never ends
+func (unlockContext) Err() error {
+	return nil
+}
+
+// Value returns the value held for a key by the context the lock was taken with, apart from the idempotency key
+func (u unlockContext) Value(key interface{}) interface{} {
+	if _, ok := key.(idempotencyKeyType); ok {
+		return nil
+	}
+	return u.Context.Value(key)
+}
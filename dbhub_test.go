@@ -0,0 +1,937 @@
+package dbhub_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+// newConnection returns a connection which sends its requests to the given test server
+func newConnection(t *testing.T, s *httptest.Server) dbhub.Connection {
+	t.Helper()
+	c, err := dbhub.New("test-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ChangeServer(s.URL)
+	return c
+}
+
+func TestServerTime(t *testing.T) {
+	serverTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		failures int32 // Number of 503 responses given before succeeding
+		retries  int
+		breaker  *dbhub.CircuitBreaker
+		wantErr  error
+		wantReqs int32
+	}{
+		{name: "success", wantReqs: 1},
+		{name: "retried", failures: 2, retries: 2, wantReqs: 3},
+		{name: "circuit open", breaker: &dbhub.CircuitBreaker{Threshold: 1, Cooldown: time.Hour}, failures: 1,
+			wantErr: dbhub.ErrCircuitOpen, wantReqs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqs int32
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					t.Errorf("got a %s request, want HEAD", r.Method)
+				}
+				w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+				if atomic.AddInt32(&reqs, 1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer s.Close()
+			c := newConnection(t, s)
+			c.Retry = dbhub.RetryPolicy{MaxRetries: tt.retries, Delay: time.Millisecond}
+			c.Breaker = tt.breaker
+			var hooked int32
+			c.OnRequest = func(ctx context.Context, info dbhub.RequestInfo) { atomic.AddInt32(&hooked, 1) }
+
+			// A tripped breaker rejects the request without sending it
+			if tt.breaker != nil {
+				c.ServerTime()
+			}
+			got, _, err := c.ServerTime()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if n := atomic.LoadInt32(&reqs); n != tt.wantReqs {
+				t.Errorf("server got %d requests, want %d", n, tt.wantReqs)
+			}
+			if n := atomic.LoadInt32(&hooked); n != tt.wantReqs {
+				t.Errorf("OnRequest saw %d requests, want %d", n, tt.wantReqs)
+			}
+			if err == nil && !got.Equal(serverTime) {
+				t.Errorf("got server time %v, want %v", got, serverTime)
+			}
+		})
+	}
+}
+
+func TestExecuteResultErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantErr     error
+		wantNotLive bool
+		wantChanged int64
+	}{
+		{name: "success", status: 200, body: `{"rows_changed":2,"last_insert_id":7}`, wantChanged: 2},
+		{name: "not live", status: 400, body: `{"error":"Only live databases can be changed","status":"not_live"}`,
+			wantErr: dbhub.ErrNotLiveDatabase, wantNotLive: true},
+		{name: "not live in body", status: 200, body: `{"status":"not_live"}`, wantErr: dbhub.ErrNotLiveDatabase,
+			wantNotLive: true},
+		{name: "live database locked", status: 400, body: `{"error":"live database is locked"}`,
+			wantErr: dbhub.ErrDatabaseLocked},
+		{name: "message mentioning live databases", status: 400, body: `{"error":"live database query failed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("execute", tt.status, tt.body)
+			res, err := s.Connection().ExecuteResult("me", "live.sqlite", "UPDATE t SET a = 1")
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := errors.Is(err, dbhub.ErrNotLiveDatabase); got != tt.wantNotLive {
+				t.Errorf("error %v wraps ErrNotLiveDatabase: %v, want %v", err, got, tt.wantNotLive)
+			}
+			if tt.status != 200 && err == nil {
+				t.Error("no error returned")
+			}
+			if res.RowsChanged != tt.wantChanged {
+				t.Errorf("got %d rows changed, want %d", res.RowsChanged, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestContextVariantsUseContext(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, c dbhub.Connection) error
+	}{
+		{"BlobReader", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.BlobReaderContext(ctx, "me", "db.sqlite", "t", "id", 1, "data")
+			return err
+		}},
+		{"Copy", func(ctx context.Context, c dbhub.Connection) error {
+			return c.CopyContext(ctx, "you", "db.sqlite", "copy.sqlite")
+		}},
+		{"CreateIndex", func(ctx context.Context, c dbhub.Connection) error {
+			return c.CreateIndexContext(ctx, "me", "live.sqlite", "idx", "t", []string{"a"}, false)
+		}},
+		{"DropIndex", func(ctx context.Context, c dbhub.Connection) error {
+			return c.DropIndexContext(ctx, "me", "live.sqlite", "idx")
+		}},
+		{"ExecScript", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.ExecScriptContext(ctx, "me", "live.sqlite", "DELETE FROM t", dbhub.ExecScriptOptions{})
+			return err
+		}},
+		{"Optimize", func(ctx context.Context, c dbhub.Connection) error {
+			return c.OptimizeContext(ctx, "me", "live.sqlite")
+		}},
+		{"PrimaryKey", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.PrimaryKeyContext(ctx, "me", "db.sqlite", "t")
+			return err
+		}},
+		{"ResolveRef", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.ResolveRefContext(ctx, "me", "db.sqlite", "main")
+			return err
+		}},
+		{"Summary", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.SummaryContext(ctx, "me", "db.sqlite")
+			return err
+		}},
+		{"UploadIfChanged", func(ctx context.Context, c dbhub.Connection) error {
+			b := []byte("SQLite format 3")
+			_, _, err := c.UploadIfChangedContext(ctx, "db.sqlite", dbhub.UploadInformation{}, &b)
+			return err
+		}},
+		{"Upsert", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.UpsertContext(ctx, "me", "live.sqlite", "t", []string{"a"}, [][]interface{}{{1}})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server doesn't reply until the test is over, so the request only ends when its context does
+			done := make(chan struct{})
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-done:
+				}
+			}))
+			defer s.Close()
+			defer close(done)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := tt.fn(ctx, newConnection(t, s))
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v, want context.DeadlineExceeded", err)
+			}
+		})
+	}
+}
+
+func TestEndpointDeadlines(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, c dbhub.Connection) error
+	}{
+		{"Columns", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.ColumnsContext(ctx, "me", "db.sqlite", dbhub.Identifier{}, "t")
+			return err
+		}},
+		{"Commits", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.CommitsContext(ctx, "me", "db.sqlite")
+			return err
+		}},
+		{"Delete", func(ctx context.Context, c dbhub.Connection) error {
+			return c.DeleteContext(ctx, "db.sqlite")
+		}},
+		{"Diff", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.DiffContext(ctx, "me", "db.sqlite", dbhub.Identifier{CommitID: "c1"}, "", "",
+				dbhub.Identifier{CommitID: "c2"}, dbhub.NoMerge)
+			return err
+		}},
+		{"Metadata", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.MetadataContext(ctx, "me", "db.sqlite")
+			return err
+		}},
+		{"Query", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.QueryContext(ctx, "me", "db.sqlite", dbhub.Identifier{}, false, "SELECT 1")
+			return err
+		}},
+		{"Tables", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.TablesContext(ctx, "me", "db.sqlite", dbhub.Identifier{})
+			return err
+		}},
+		{"Upload", func(ctx context.Context, c dbhub.Connection) error {
+			b := []byte("SQLite format 3")
+			return c.UploadContext(ctx, "db.sqlite", dbhub.UploadInformation{}, &b)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server sleeps for far longer than the deadline before replying.  It reads the request first, so it
+			// notices the client going away
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(ioutil.Discard, r.Body)
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+				fmt.Fprint(w, `{}`)
+			}))
+			defer s.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := tt.fn(ctx, newConnection(t, s))
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v, want context.DeadlineExceeded", err)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("returned after %v, long after the deadline", d)
+			}
+		})
+	}
+}
+
+// cancelAfterRead is a transport which cancels the context of the request as soon as part of the response body has
+// been read
+type cancelAfterRead struct {
+	rt     http.RoundTripper
+	cancel context.CancelFunc
+}
+
+func (c cancelAfterRead) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	resp, err = c.rt.RoundTrip(req)
+	if err == nil {
+		resp.Body = cancellingBody{resp.Body, c.cancel}
+	}
+	return
+}
+
+// cancellingBody is a response body which cancels the context of its request after each read
+type cancellingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancellingBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.cancel()
+	return
+}
+
+func TestPartialResponseCancel(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		partial  string
+		endpoint string
+		fn       func(ctx context.Context, c dbhub.Connection) error
+	}{
+		{"Tables", 200, `["a","b"`, "tables", func(ctx context.Context, c dbhub.Connection) error {
+			_, err := c.TablesContext(ctx, "me", "db.sqlite", dbhub.Identifier{})
+			return err
+		}},
+		{"QueryStream", 200, `[[{"Name":"n","Type":4,"Value":1}],`, "query",
+			func(ctx context.Context, c dbhub.Connection) error {
+				return c.QueryStreamContext(ctx, "me", "db.sqlite", false, "SELECT n FROM t",
+					func(dbhub.ResultRow) error { return nil })
+			}},
+		{"Upload", 201, `{"commit_id":`, "upload", func(ctx context.Context, c dbhub.Connection) error {
+			b := []byte("SQLite format 3")
+			return c.UploadContext(ctx, "db.sqlite", dbhub.UploadInformation{}, &b)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server sends the start of its response, then hangs until the client goes away.  The context is
+			// cancelled once that start has arrived
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(ioutil.Discard, r.Body)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.partial))
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			}))
+			defer s.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c := newConnection(t, s)
+			c.HTTPClient = &http.Client{Transport: cancelAfterRead{http.DefaultTransport, cancel}}
+			err := tt.fn(ctx, c)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, want context.Canceled", err)
+			}
+			if want := "reading " + tt.endpoint + " response"; !strings.Contains(err.Error(), want) {
+				t.Errorf("got error %q, want it to mention %q", err, want)
+			}
+		})
+	}
+}
+
+func TestUnlockAfterContextEnds(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("lock", 200, `{"lock_id":"abc"}`)
+	s.Handle("unlock", 200, `{}`)
+	c := s.Connection()
+	type ctxKey struct{}
+	var unlockValue interface{}
+	c.OnRequest = func(ctx context.Context, info dbhub.RequestInfo) {
+		if info.Endpoint == "unlock" {
+			unlockValue = ctx.Value(ctxKey{})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "traced"))
+	unlock, err := c.LockContext(ctx, "me", "live.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	err = unlock()
+	if err != nil {
+		t.Fatalf("releasing the lock after the context ended: %v", err)
+	}
+	if unlockValue != "traced" {
+		t.Errorf("unlock request context holds %v, want the value from the lock context", unlockValue)
+	}
+	reqs := s.Requests()
+	if len(reqs) != 2 || reqs[1].Endpoint != "unlock" || reqs[1].Form.Get("lock_id") != "abc" {
+		t.Errorf("got requests %+v", reqs)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		response string
+		fn       func(c dbhub.Connection) (interface{}, error)
+		want     string
+		wantForm map[string]string
+	}{
+		{"Tables", "tables", `["a","b"]`, func(c dbhub.Connection) (interface{}, error) {
+			return c.Tables("me", "db.sqlite", dbhub.Identifier{Branch: "dev"})
+		}, "[a b]", map[string]string{"dbowner": "me", "dbname": "db.sqlite", "branch": "dev"}},
+		{"Query", "query", `[[{"Name":"n","Type":4,"Value":3}]]`, func(c dbhub.Connection) (interface{}, error) {
+			res, err := c.Query("me", "db.sqlite", dbhub.Identifier{CommitID: "c1"}, false, "SELECT n FROM t")
+			return res.Rows, err
+		}, "[{[3]}]", map[string]string{"commit": "c1", "sql": "U0VMRUNUIG4gRlJPTSB0"}},
+		{"Commits", "commits", `{"c1":{"id":"c1","message":"First"}}`, func(c dbhub.Connection) (interface{}, error) {
+			commits, err := c.Commits("me", "db.sqlite")
+			return commits["c1"].Message, err
+		}, "First", map[string]string{"dbowner": "me", "dbname": "db.sqlite"}},
+		{"Databases", "databases", `["a.sqlite","b.sqlite"]`, func(c dbhub.Connection) (interface{}, error) {
+			return c.Databases()
+		}, "[a.sqlite b.sqlite]", map[string]string{"dbowner": "", "live": ""}},
+		{"LiveDatabases", "databases", `["live.sqlite"]`, func(c dbhub.Connection) (interface{}, error) {
+			return c.LiveDatabases()
+		}, "[live.sqlite]", map[string]string{"dbowner": "", "live": "true"}},
+		{"UserDatabases", "databases", `["a.sqlite"]`, func(c dbhub.Connection) (interface{}, error) {
+			dbs, err := c.UserDatabases("someone")
+			return len(dbs), err
+		}, "1", map[string]string{"dbowner": "someone", "live": ""}},
+		{"Delete", "delete", `{}`, func(c dbhub.Connection) (interface{}, error) {
+			return nil, c.Delete("old.sqlite")
+		}, "<nil>", map[string]string{"dbname": "old.sqlite"}},
+		{"Rename", "rename", `{}`, func(c dbhub.Connection) (interface{}, error) {
+			return nil, c.Rename("old.sqlite", "new.sqlite")
+		}, "<nil>", map[string]string{"dbname": "old.sqlite", "newname": "new.sqlite"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle(tt.endpoint, 200, tt.response)
+			got, err := tt.fn(s.Connection())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g := fmt.Sprint(got); g != tt.want {
+				t.Errorf("got %s, want %s", g, tt.want)
+			}
+			reqs := s.Requests()
+			if len(reqs) != 1 || reqs[0].Endpoint != tt.endpoint {
+				t.Fatalf("got requests %+v", reqs)
+			}
+			if reqs[0].APIKey != "test-api-key" {
+				t.Errorf("sent API key %q", reqs[0].APIKey)
+			}
+			for k, v := range tt.wantForm {
+				if got := reqs[0].Form.Get(k); got != v {
+					t.Errorf("sent %s=%q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmDestructive(t *testing.T) {
+	tests := []struct {
+		name       string
+		fn         func(c dbhub.Connection) error
+		wantAction string
+	}{
+		{"delete", func(c dbhub.Connection) error { return c.Delete("db.sqlite") }, "delete"},
+		{"rename", func(c dbhub.Connection) error { return c.Rename("db.sqlite", "new.sqlite") }, "rename"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			c := s.Connection()
+			var action, dbName string
+			c.ConfirmDestructive = func(a, n string) bool {
+				action, dbName = a, n
+				return false
+			}
+			err := tt.fn(c)
+			if !errors.Is(err, dbhub.ErrNotConfirmed) {
+				t.Errorf("got error %v, want ErrNotConfirmed", err)
+			}
+			if action != tt.wantAction || dbName != "db.sqlite" {
+				t.Errorf("check given %q for %q", action, dbName)
+			}
+			if n := len(s.Requests()); n != 0 {
+				t.Errorf("%d requests sent, want none", n)
+			}
+		})
+	}
+}
+
+func TestRenameExisting(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("rename", 409, `{"error":"a database with that name already exists"}`)
+	err := s.Connection().Rename("old.sqlite", "new.sqlite")
+	if !errors.Is(err, dbhub.ErrDatabaseExists) {
+		t.Errorf("got error %v, want ErrDatabaseExists", err)
+	}
+}
+
+func TestCopyExisting(t *testing.T) {
+	tests := []struct {
+		name        string
+		databases   string
+		wantUploads int
+	}{
+		{"listed", `["copy.sqlite"]`, 0},
+		// The database is created between the check and the upload, so the server refuses the upload
+		{"created meanwhile", `[]`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("databases", 200, tt.databases)
+			s.Handle("download", 200, "SQLite format 3\x00")
+			s.Handle("upload", 403, `{"error":"A database with that name already exists.  Please choose a different `+
+				`name or clone the existing database first."}`)
+			err := s.Connection().Copy("you", "db.sqlite", "copy.sqlite")
+			if !errors.Is(err, dbhub.ErrDatabaseExists) {
+				t.Errorf("got error %v, want ErrDatabaseExists", err)
+			}
+			uploads := 0
+			for _, req := range s.Requests() {
+				if req.Endpoint == "upload" {
+					uploads++
+					if _, ok := req.Form["commit"]; ok {
+						t.Errorf("upload sent with a parent commit, so it could add to an existing database")
+					}
+				}
+			}
+			if uploads != tt.wantUploads {
+				t.Errorf("got %d uploads, want %d", uploads, tt.wantUploads)
+			}
+		})
+	}
+}
+
+func TestSetDatabaseLicence(t *testing.T) {
+	tests := []struct {
+		name    string
+		licence string
+		wantErr bool
+	}{
+		{"valid", "CC-BY-SA-4.0", false},
+		{"empty", "", true},
+		{"too long", "Some Licence Of My Own", true},
+		{"invalid characters", "CC0;DROP", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("setlicence", 200, `{}`)
+			err := s.Connection().SetDatabaseLicence("me", "db.sqlite", tt.licence)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+			reqs := s.Requests()
+			if tt.wantErr {
+				if len(reqs) != 0 {
+					t.Errorf("invalid licence sent to the server")
+				}
+				return
+			}
+			if len(reqs) != 1 || reqs[0].Form.Get("licence") != tt.licence {
+				t.Errorf("got requests %+v", reqs)
+			}
+		})
+	}
+}
+
+func TestLockTwice(t *testing.T) {
+	// The server holds a single lock, refusing to hand out another until it's released
+	var locked, unlocks int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/lock":
+			if !atomic.CompareAndSwapInt32(&locked, 0, 1) {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"error":"database is locked by someone else"}`)
+				return
+			}
+			fmt.Fprint(w, `{"lock_id":"l1"}`)
+		case "/v1/unlock":
+			atomic.AddInt32(&unlocks, 1)
+			atomic.StoreInt32(&locked, 0)
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+	c := newConnection(t, s)
+
+	unlock, err := c.Lock("me", "live.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Lock("me", "live.sqlite"); !errors.Is(err, dbhub.ErrDatabaseLocked) {
+		t.Errorf("second lock got error %v, want ErrDatabaseLocked", err)
+	}
+
+	// Releasing the lock more than once only sends a single unlock request
+	for i := 0; i < 2; i++ {
+		if err = unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&unlocks); n != 1 {
+		t.Errorf("sent %d unlock requests, want 1", n)
+	}
+	unlock, err = c.Lock("me", "live.sqlite")
+	if err != nil {
+		t.Fatalf("lock after unlocking got error %v", err)
+	}
+	unlock()
+}
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantMessage string
+	}{
+		{"bad request", 400, `{"error":"Invalid table name"}`, "Invalid table name"},
+		{"invalid API key", 401, `{"error":"Incorrect or unknown API key and certificate"}`,
+			"Incorrect or unknown API key and certificate"},
+		{"missing database", 404, `{"error":"Database not found"}`, "Database not found"},
+		{"no message", 404, `Not found`, "404 Not Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("tables", tt.status, tt.body)
+			_, err := s.Connection().Tables("me", "db.sqlite", dbhub.Identifier{})
+			var e *dbhub.APIError
+			if !errors.As(err, &e) {
+				t.Fatalf("got error %v, want an APIError", err)
+			}
+			if e.Code != tt.status || e.Endpoint != "tables" || e.Message != tt.wantMessage {
+				t.Errorf("got %+v, want code %d for tables with message %q", e, tt.status, tt.wantMessage)
+			}
+			if err.Error() != tt.wantMessage {
+				t.Errorf("got error text %q, want %q", err, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestGarbageResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		body     string
+		fn       func(c dbhub.Connection) error
+	}{
+		{"not JSON", "tables", `<html>Bad gateway</html>`, func(c dbhub.Connection) error {
+			_, err := c.Tables("me", "db.sqlite", dbhub.Identifier{})
+			return err
+		}},
+		{"cut short", "query", `[[{"Name":"n","Type":4,"Val`, func(c dbhub.Connection) error {
+			_, err := c.Query("me", "db.sqlite", dbhub.Identifier{}, false, "SELECT n FROM t")
+			return err
+		}},
+		{"wrong type", "branches", `{"branches":5}`, func(c dbhub.Connection) error {
+			_, _, err := c.Branches("me", "db.sqlite")
+			return err
+		}},
+		{"empty", "views", ``, func(c dbhub.Connection) error {
+			_, err := c.Views("me", "db.sqlite", dbhub.Identifier{})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle(tt.endpoint, 200, tt.body)
+			err := tt.fn(s.Connection())
+			if err == nil {
+				t.Fatal("no error returned")
+			}
+			if want := "decoding " + tt.endpoint + " response: "; !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("got error %q, want it to start with %q", err, want)
+			}
+			var e *dbhub.APIError
+			if errors.As(err, &e) {
+				t.Errorf("decoding error reported as an APIError: %v", err)
+			}
+		})
+	}
+}
+
+// tableCount is an example of code taking a DBHubAPI, so it can be tested with a Fake
+func tableCount(api dbhub.DBHubAPI, dbOwner, dbName string) (n int, err error) {
+	tables, err := api.Tables(dbOwner, dbName, dbhub.Identifier{})
+	n = len(tables)
+	return
+}
+
+func TestFake(t *testing.T) {
+	f := &dbhubtest.Fake{TablesFunc: func(dbOwner, dbName string, ident dbhub.Identifier) ([]string, error) {
+		if dbOwner != "me" || dbName != "db.sqlite" {
+			t.Errorf("Tables called for %s/%s", dbOwner, dbName)
+		}
+		return []string{"a", "b"}, nil
+	}}
+	n, err := tableCount(f, "me", "db.sqlite")
+	if err != nil || n != 2 {
+		t.Errorf("got %d tables and error %v, want 2 tables", n, err)
+	}
+
+	// Methods without a function aren't implemented
+	_, err = f.Views("me", "db.sqlite", dbhub.Identifier{})
+	if !errors.Is(err, dbhubtest.ErrNotImplemented) {
+		t.Errorf("got error %v, want ErrNotImplemented", err)
+	}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		targetURL string
+		events    []string
+		wantErr   bool
+	}{
+		{"https", "https://example.com/hook", []string{"commit", "release"}, false},
+		{"http with port", "http://example.com:8080/hook?db=1", nil, false},
+		{"not a URL", "://bad", nil, true},
+		{"other scheme", "ftp://example.com/hook", nil, true},
+		{"relative", "/hook", nil, true},
+		{"no host", "https:///hook", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("createwebhook", 200, `{"id":"hook1"}`)
+			id, err := s.Connection().CreateWebhook("me", "db.sqlite", tt.targetURL, tt.events)
+			reqs := s.Requests()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("no error returned")
+				}
+				if len(reqs) != 0 {
+					t.Errorf("%d requests sent for an invalid URL, want none", len(reqs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "hook1" {
+				t.Errorf("got webhook id %q, want hook1", id)
+			}
+			if len(reqs) != 1 {
+				t.Fatalf("got %d requests, want 1", len(reqs))
+			}
+			f := reqs[0].Form
+			if f.Get("dbowner") != "me" || f.Get("dbname") != "db.sqlite" || f.Get("url") != tt.targetURL {
+				t.Errorf("sent form %v", f)
+			}
+			if fmt.Sprint(f["event"]) != fmt.Sprint(tt.events) {
+				t.Errorf("sent events %v, want %v", f["event"], tt.events)
+			}
+		})
+	}
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"deleted", 200, `{}`, false},
+		{"unknown webhook", 404, `{"error":"no such webhook"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("deletewebhook", tt.status, tt.body)
+			err := s.Connection().DeleteWebhook("me", "db.sqlite", "hook1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+			var e *dbhub.APIError
+			if tt.wantErr && (!errors.As(err, &e) || e.Code != tt.status) {
+				t.Errorf("got error %v, want an APIError with code %d", err, tt.status)
+			}
+			reqs := s.Requests()
+			if len(reqs) != 1 || reqs[0].Form.Get("id") != "hook1" || reqs[0].Form.Get("dbname") != "db.sqlite" {
+				t.Errorf("got requests %+v", reqs)
+			}
+		})
+	}
+}
+
+func TestUploadCircuitBreaker(t *testing.T) {
+	var reqs int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reqs, 1)
+		w.WriteHeader(503)
+		w.Write([]byte(`{"error":"server unavailable"}`))
+	}))
+	defer s.Close()
+	c := newConnection(t, s)
+	c.Breaker = dbhub.NewCircuitBreaker(1, time.Hour)
+	c.Retry = dbhub.RetryPolicy{MaxRetries: 3}
+
+	// The failed upload isn't retried, but opens the circuit breaker so the next upload isn't sent
+	db := []byte("SQLite format 3\x00")
+	if err := c.Upload("db.sqlite", dbhub.UploadInformation{}, &db); err == nil || errors.Is(err, dbhub.ErrCircuitOpen) {
+		t.Errorf("first upload got error %v, want the server error", err)
+	}
+	if err := c.Upload("db.sqlite", dbhub.UploadInformation{}, &db); !errors.Is(err, dbhub.ErrCircuitOpen) {
+		t.Errorf("second upload got error %v, want ErrCircuitOpen", err)
+	}
+	if n := atomic.LoadInt32(&reqs); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	var stored []byte
+	var branch string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/upload":
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, `{"error":%q}`, err.Error())
+				return
+			}
+			defer f.Close()
+			stored, _ = ioutil.ReadAll(f)
+			branch = r.FormValue("branch")
+			w.WriteHeader(201)
+			w.Write([]byte(`{"commit_id":"c1","url":"https://dbhub.io/me/db.sqlite"}`))
+		case "/v1/download":
+			w.Write(stored)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer s.Close()
+	c := newConnection(t, s)
+
+	// A database file larger than the in memory limit of the form parsing, holding every byte value
+	db := make([]byte, 3<<20)
+	for i := range db {
+		db[i] = byte(i % 251)
+	}
+	commitID, err := c.UploadReader("db.sqlite", bytes.NewReader(db),
+		dbhub.UploadInformation{Ident: dbhub.Identifier{Branch: "main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commitID != "c1" {
+		t.Errorf("got commit ID %q, want c1", commitID)
+	}
+	if branch != "main" {
+		t.Errorf("uploaded to branch %q, want main", branch)
+	}
+	rc, err := c.Download("me", "db.sqlite", dbhub.Identifier{CommitID: commitID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("downloaded %d bytes which differ from the %d bytes uploaded", len(got), len(db))
+	}
+}
+
+func TestUploadQuotaExceeded(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantLimit int64
+		wantUsage int64
+		wantMsg   string
+	}{
+		{"insufficient storage", 507, `{"error":"quota exceeded","limit":1000,"usage":990}`, 1000, 990,
+			"storage quota exceeded (990 of 1000 bytes used)"},
+		{"quota status", 403, `{"error":"quota exceeded","status":"quota_exceeded"}`, 0, 0, "storage quota exceeded"},
+		{"no body", 507, ``, 0, 0, "storage quota exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("upload", tt.status, tt.body)
+			db := []byte("SQLite format 3\x00")
+			err := s.Connection().Upload("db.sqlite", dbhub.UploadInformation{}, &db)
+			var quota *dbhub.QuotaExceededError
+			if !errors.As(err, &quota) {
+				t.Fatalf("got error %v, want a QuotaExceededError", err)
+			}
+			if quota.Limit != tt.wantLimit || quota.Usage != tt.wantUsage {
+				t.Errorf("got limit %d and usage %d, want %d and %d", quota.Limit, quota.Usage, tt.wantLimit,
+					tt.wantUsage)
+			}
+			if quota.Error() != tt.wantMsg {
+				t.Errorf("got message %q, want %q", quota.Error(), tt.wantMsg)
+			}
+			var apiErr *dbhub.APIError
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.status || apiErr.Endpoint != "upload" {
+				t.Errorf("got error %#v, want an APIError for status %d from upload", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestBackupAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbhub-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("databases", 200, `["a.sqlite","b.sqlite"]`)
+	s.Handle("download", 200, "SQLite format 3\x00")
+
+	results, err := s.Connection().BackupAll(filepath.Join(dir, "backups"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].DBName != "a.sqlite" || results[1].DBName != "b.sqlite" {
+		t.Fatalf("got results %+v", results)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.DBName, r.Err)
+			continue
+		}
+		b, err := ioutil.ReadFile(r.Path)
+		if err != nil {
+			t.Errorf("%s: %v", r.DBName, err)
+		} else if string(b) != "SQLite format 3\x00" {
+			t.Errorf("%s: saved %q", r.DBName, b)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+// Package dbhubtest provides helpers for testing code which uses the dbhub package, without needing a DBHub.io server
+package dbhubtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+)
+
+// ErrNotImplemented is returned by the methods of Fake which haven't been given a function to call
+var ErrNotImplemented = errors.New("not implemented by the fake")
+
+// Fake is an implementation of dbhub.DBHubAPI for use in tests.  Each method calls the function in the matching field
+// (eg Query() calls QueryFunc), so tests only need to set the ones the code being tested uses.  Methods without a
+// function return an error wrapping ErrNotImplemented.
+type Fake struct {
+	AccessLevelFunc        func(string, string) (dbhub.AccessLevel, error)
+	BranchesFunc           func(string, string) (map[string]com.BranchEntry, string, error)
+	ColumnsFunc            func(string, string, dbhub.Identifier, string) ([]com.APIJSONColumn, error)
+	CommitsFunc            func(string, string) (map[string]com.CommitEntry, error)
+	CreateWebhookFunc      func(string, string, string, []string) (string, error)
+	DatabaseLicenceFunc    func(string, string) (com.LicenceEntry, error)
+	DatabasesFunc          func() ([]string, error)
+	DeleteFunc             func(string) error
+	DeleteWebhookFunc      func(string, string, string) error
+	DiffFunc               func(string, string, dbhub.Identifier, string, string, dbhub.Identifier, dbhub.MergeStrategy) (com.Diffs, error)
+	DownloadFunc           func(string, string, dbhub.Identifier) (io.ReadCloser, error)
+	ExecuteFunc            func(string, string, string) (int64, error)
+	ExecuteResultFunc      func(string, string, string) (dbhub.ExecResult, error)
+	ForkFunc               func(string, string) (string, string, error)
+	IndexesFunc            func(string, string, dbhub.Identifier) ([]com.APIJSONIndex, error)
+	LabelsFunc             func(string, string) (map[string]string, error)
+	LiveDatabasesFunc      func() ([]string, error)
+	LiveLockStatusFunc     func(string, string) (bool, string, error)
+	LockFunc               func(string, string) (func() error, error)
+	MergeFunc              func(string, string, string, string, string) (string, error)
+	MetadataFunc           func(string, string) (com.MetadataResponseContainer, error)
+	QueryDefaultFunc       func(string, string, dbhub.Identifier, string) (dbhub.Results, error)
+	QueryFunc              func(string, string, dbhub.Identifier, bool, string) (dbhub.Results, error)
+	QueryPreparedFunc      func(string, string, string, []interface{}) (dbhub.Results, error)
+	ReleasesFunc           func(string, string) (map[string]com.ReleaseEntry, error)
+	RenameFunc             func(string, string) error
+	ServerTimeFunc         func() (time.Time, time.Duration, error)
+	SetDatabaseLicenceFunc func(string, string, string) error
+	SetLabelFunc           func(string, string, string, string) error
+	StarFunc               func(string, string) error
+	TablesFunc             func(string, string, dbhub.Identifier) ([]string, error)
+	TagsFunc               func(string, string) (map[string]com.TagEntry, error)
+	UnstarFunc             func(string, string) error
+	UnwatchFunc            func(string, string) error
+	UploadFunc             func(string, dbhub.UploadInformation, *[]byte) error
+	UploadReaderFunc       func(string, io.Reader, dbhub.UploadInformation) (string, error)
+	UserDatabasesFunc      func(string) ([]com.DBEntry, error)
+	ViewsFunc              func(string, string, dbhub.Identifier) ([]string, error)
+	WatchFunc              func(string, string) error
+	WebhooksFunc           func(string, string) ([]dbhub.Webhook, error)
+	WebpageFunc            func(string, string) (com.WebpageResponseContainer, error)
+}
+
+// Make sure Fake stays usable as a DBHubAPI
+var _ dbhub.DBHubAPI = &Fake{}
+
+// AccessLevel calls AccessLevelFunc
+func (f *Fake) AccessLevel(dbOwner, dbName string) (level dbhub.AccessLevel, err error) {
+	if f.AccessLevelFunc == nil {
+		err = notImplemented("AccessLevel")
+		return
+	}
+	return f.AccessLevelFunc(dbOwner, dbName)
+}
+
+// Branches calls BranchesFunc
+func (f *Fake) Branches(dbOwner, dbName string) (branches map[string]com.BranchEntry, defaultBranch string, err error) {
+	if f.BranchesFunc == nil {
+		err = notImplemented("Branches")
+		return
+	}
+	return f.BranchesFunc(dbOwner, dbName)
+}
+
+// Columns calls ColumnsFunc
+func (f *Fake) Columns(dbOwner, dbName string, ident dbhub.Identifier, table string) (columns []com.APIJSONColumn, err error) {
+	if f.ColumnsFunc == nil {
+		err = notImplemented("Columns")
+		return
+	}
+	return f.ColumnsFunc(dbOwner, dbName, ident, table)
+}
+
+// Commits calls CommitsFunc
+func (f *Fake) Commits(dbOwner, dbName string) (commits map[string]com.CommitEntry, err error) {
+	if f.CommitsFunc == nil {
+		err = notImplemented("Commits")
+		return
+	}
+	return f.CommitsFunc(dbOwner, dbName)
+}
+
+// CreateWebhook calls CreateWebhookFunc
+func (f *Fake) CreateWebhook(dbOwner, dbName, targetURL string, events []string) (id string, err error) {
+	if f.CreateWebhookFunc == nil {
+		err = notImplemented("CreateWebhook")
+		return
+	}
+	return f.CreateWebhookFunc(dbOwner, dbName, targetURL, events)
+}
+
+// DatabaseLicence calls DatabaseLicenceFunc
+func (f *Fake) DatabaseLicence(dbOwner, dbName string) (licence com.LicenceEntry, err error) {
+	if f.DatabaseLicenceFunc == nil {
+		err = notImplemented("DatabaseLicence")
+		return
+	}
+	return f.DatabaseLicenceFunc(dbOwner, dbName)
+}
+
+// Databases calls DatabasesFunc
+func (f *Fake) Databases() (databases []string, err error) {
+	if f.DatabasesFunc == nil {
+		err = notImplemented("Databases")
+		return
+	}
+	return f.DatabasesFunc()
+}
+
+// Delete calls DeleteFunc
+func (f *Fake) Delete(dbName string) (err error) {
+	if f.DeleteFunc == nil {
+		err = notImplemented("Delete")
+		return
+	}
+	return f.DeleteFunc(dbName)
+}
+
+// DeleteWebhook calls DeleteWebhookFunc
+func (f *Fake) DeleteWebhook(dbOwner, dbName, id string) (err error) {
+	if f.DeleteWebhookFunc == nil {
+		err = notImplemented("DeleteWebhook")
+		return
+	}
+	return f.DeleteWebhookFunc(dbOwner, dbName, id)
+}
+
+// Diff calls DiffFunc
+func (f *Fake) Diff(dbOwnerA, dbNameA string, identA dbhub.Identifier, dbOwnerB, dbNameB string, identB dbhub.Identifier, merge dbhub.MergeStrategy) (diffs com.Diffs, err error) {
+	if f.DiffFunc == nil {
+		err = notImplemented("Diff")
+		return
+	}
+	return f.DiffFunc(dbOwnerA, dbNameA, identA, dbOwnerB, dbNameB, identB, merge)
+}
+
+// Download calls DownloadFunc
+func (f *Fake) Download(dbOwner, dbName string, ident dbhub.Identifier) (db io.ReadCloser, err error) {
+	if f.DownloadFunc == nil {
+		err = notImplemented("Download")
+		return
+	}
+	return f.DownloadFunc(dbOwner, dbName, ident)
+}
+
+// Execute calls ExecuteFunc
+func (f *Fake) Execute(dbOwner, dbName, sql string) (rowsChanged int64, err error) {
+	if f.ExecuteFunc == nil {
+		err = notImplemented("Execute")
+		return
+	}
+	return f.ExecuteFunc(dbOwner, dbName, sql)
+}
+
+// ExecuteResult calls ExecuteResultFunc
+func (f *Fake) ExecuteResult(dbOwner, dbName, sql string) (result dbhub.ExecResult, err error) {
+	if f.ExecuteResultFunc == nil {
+		err = notImplemented("ExecuteResult")
+		return
+	}
+	return f.ExecuteResultFunc(dbOwner, dbName, sql)
+}
+
+// Fork calls ForkFunc
+func (f *Fake) Fork(dbOwner, dbName string) (newOwner, newName string, err error) {
+	if f.ForkFunc == nil {
+		err = notImplemented("Fork")
+		return
+	}
+	return f.ForkFunc(dbOwner, dbName)
+}
+
+// Indexes calls IndexesFunc
+func (f *Fake) Indexes(dbOwner, dbName string, ident dbhub.Identifier) (idx []com.APIJSONIndex, err error) {
+	if f.IndexesFunc == nil {
+		err = notImplemented("Indexes")
+		return
+	}
+	return f.IndexesFunc(dbOwner, dbName, ident)
+}
+
+// Labels calls LabelsFunc
+func (f *Fake) Labels(dbOwner, dbName string) (labels map[string]string, err error) {
+	if f.LabelsFunc == nil {
+		err = notImplemented("Labels")
+		return
+	}
+	return f.LabelsFunc(dbOwner, dbName)
+}
+
+// LiveDatabases calls LiveDatabasesFunc
+func (f *Fake) LiveDatabases() (databases []string, err error) {
+	if f.LiveDatabasesFunc == nil {
+		err = notImplemented("LiveDatabases")
+		return
+	}
+	return f.LiveDatabasesFunc()
+}
+
+// LiveLockStatus calls LiveLockStatusFunc
+func (f *Fake) LiveLockStatus(dbOwner, dbName string) (locked bool, holder string, err error) {
+	if f.LiveLockStatusFunc == nil {
+		err = notImplemented("LiveLockStatus")
+		return
+	}
+	return f.LiveLockStatusFunc(dbOwner, dbName)
+}
+
+// Lock calls LockFunc
+func (f *Fake) Lock(dbOwner, dbName string) (unlock func() error, err error) {
+	if f.LockFunc == nil {
+		err = notImplemented("Lock")
+		return
+	}
+	return f.LockFunc(dbOwner, dbName)
+}
+
+// Merge calls MergeFunc
+func (f *Fake) Merge(dbOwner, dbName, sourceBranch, targetBranch, message string) (commitID string, err error) {
+	if f.MergeFunc == nil {
+		err = notImplemented("Merge")
+		return
+	}
+	return f.MergeFunc(dbOwner, dbName, sourceBranch, targetBranch, message)
+}
+
+// Metadata calls MetadataFunc
+func (f *Fake) Metadata(dbOwner, dbName string) (meta com.MetadataResponseContainer, err error) {
+	if f.MetadataFunc == nil {
+		err = notImplemented("Metadata")
+		return
+	}
+	return f.MetadataFunc(dbOwner, dbName)
+}
+
+// Query calls QueryFunc
+func (f *Fake) Query(dbOwner, dbName string, ident dbhub.Identifier, blobBase64 bool, sql string) (out dbhub.Results, err error) {
+	if f.QueryFunc == nil {
+		err = notImplemented("Query")
+		return
+	}
+	return f.QueryFunc(dbOwner, dbName, ident, blobBase64, sql)
+}
+
+// QueryDefault calls QueryDefaultFunc
+func (f *Fake) QueryDefault(dbOwner, dbName string, ident dbhub.Identifier, sql string) (out dbhub.Results, err error) {
+	if f.QueryDefaultFunc == nil {
+		err = notImplemented("QueryDefault")
+		return
+	}
+	return f.QueryDefaultFunc(dbOwner, dbName, ident, sql)
+}
+
+// QueryPrepared calls QueryPreparedFunc
+func (f *Fake) QueryPrepared(dbOwner, dbName, sql string, args []interface{}) (out dbhub.Results, err error) {
+	if f.QueryPreparedFunc == nil {
+		err = notImplemented("QueryPrepared")
+		return
+	}
+	return f.QueryPreparedFunc(dbOwner, dbName, sql, args)
+}
+
+// Releases calls ReleasesFunc
+func (f *Fake) Releases(dbOwner, dbName string) (releases map[string]com.ReleaseEntry, err error) {
+	if f.ReleasesFunc == nil {
+		err = notImplemented("Releases")
+		return
+	}
+	return f.ReleasesFunc(dbOwner, dbName)
+}
+
+// Rename calls RenameFunc
+func (f *Fake) Rename(dbName, newName string) (err error) {
+	if f.RenameFunc == nil {
+		err = notImplemented("Rename")
+		return
+	}
+	return f.RenameFunc(dbName, newName)
+}
+
+// ServerTime calls ServerTimeFunc
+func (f *Fake) ServerTime() (serverTime time.Time, skew time.Duration, err error) {
+	if f.ServerTimeFunc == nil {
+		err = notImplemented("ServerTime")
+		return
+	}
+	return f.ServerTimeFunc()
+}
+
+// SetDatabaseLicence calls SetDatabaseLicenceFunc
+func (f *Fake) SetDatabaseLicence(dbOwner, dbName, licence string) (err error) {
+	if f.SetDatabaseLicenceFunc == nil {
+		err = notImplemented("SetDatabaseLicence")
+		return
+	}
+	return f.SetDatabaseLicenceFunc(dbOwner, dbName, licence)
+}
+
+// SetLabel calls SetLabelFunc
+func (f *Fake) SetLabel(dbOwner, dbName, key, value string) (err error) {
+	if f.SetLabelFunc == nil {
+		err = notImplemented("SetLabel")
+		return
+	}
+	return f.SetLabelFunc(dbOwner, dbName, key, value)
+}
+
+// Star calls StarFunc
+func (f *Fake) Star(dbOwner, dbName string) (err error) {
+	if f.StarFunc == nil {
+		err = notImplemented("Star")
+		return
+	}
+	return f.StarFunc(dbOwner, dbName)
+}
+
+// Tables calls TablesFunc
+func (f *Fake) Tables(dbOwner, dbName string, ident dbhub.Identifier) (tbl []string, err error) {
+	if f.TablesFunc == nil {
+		err = notImplemented("Tables")
+		return
+	}
+	return f.TablesFunc(dbOwner, dbName, ident)
+}
+
+// Tags calls TagsFunc
+func (f *Fake) Tags(dbOwner, dbName string) (tags map[string]com.TagEntry, err error) {
+	if f.TagsFunc == nil {
+		err = notImplemented("Tags")
+		return
+	}
+	return f.TagsFunc(dbOwner, dbName)
+}
+
+// Unstar calls UnstarFunc
+func (f *Fake) Unstar(dbOwner, dbName string) (err error) {
+	if f.UnstarFunc == nil {
+		err = notImplemented("Unstar")
+		return
+	}
+	return f.UnstarFunc(dbOwner, dbName)
+}
+
+// Unwatch calls UnwatchFunc
+func (f *Fake) Unwatch(dbOwner, dbName string) (err error) {
+	if f.UnwatchFunc == nil {
+		err = notImplemented("Unwatch")
+		return
+	}
+	return f.UnwatchFunc(dbOwner, dbName)
+}
+
+// Upload calls UploadFunc
+func (f *Fake) Upload(dbName string, info dbhub.UploadInformation, dbBytes *[]byte) (err error) {
+	if f.UploadFunc == nil {
+		err = notImplemented("Upload")
+		return
+	}
+	return f.UploadFunc(dbName, info, dbBytes)
+}
+
+// UploadReader calls UploadReaderFunc
+func (f *Fake) UploadReader(dbName string, db io.Reader, info dbhub.UploadInformation) (commitID string, err error) {
+	if f.UploadReaderFunc == nil {
+		err = notImplemented("UploadReader")
+		return
+	}
+	return f.UploadReaderFunc(dbName, db, info)
+}
+
+// UserDatabases calls UserDatabasesFunc
+func (f *Fake) UserDatabases(userName string) (databases []com.DBEntry, err error) {
+	if f.UserDatabasesFunc == nil {
+		err = notImplemented("UserDatabases")
+		return
+	}
+	return f.UserDatabasesFunc(userName)
+}
+
+// Views calls ViewsFunc
+func (f *Fake) Views(dbOwner, dbName string, ident dbhub.Identifier) (views []string, err error) {
+	if f.ViewsFunc == nil {
+		err = notImplemented("Views")
+		return
+	}
+	return f.ViewsFunc(dbOwner, dbName, ident)
+}
+
+// Watch calls WatchFunc
+func (f *Fake) Watch(dbOwner, dbName string) (err error) {
+	if f.WatchFunc == nil {
+		err = notImplemented("Watch")
+		return
+	}
+	return f.WatchFunc(dbOwner, dbName)
+}
+
+// Webhooks calls WebhooksFunc
+func (f *Fake) Webhooks(dbOwner, dbName string) (hooks []dbhub.Webhook, err error) {
+	if f.WebhooksFunc == nil {
+		err = notImplemented("Webhooks")
+		return
+	}
+	return f.WebhooksFunc(dbOwner, dbName)
+}
+
+// Webpage calls WebpageFunc
+func (f *Fake) Webpage(dbOwner, dbName string) (webPage com.WebpageResponseContainer, err error) {
+	if f.WebpageFunc == nil {
+		err = notImplemented("Webpage")
+		return
+	}
+	return f.WebpageFunc(dbOwner, dbName)
+}
+
+// notImplemented returns the error for a method of Fake which hasn't been given a function to call
+func notImplemented(method string) error {
+	return fmt.Errorf("%s: %w", method, ErrNotImplemented)
+}
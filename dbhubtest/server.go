@@ -0,0 +1,104 @@
+package dbhubtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+)
+
+// Request holds the details of a request received by a Server
+type Request struct {
+	Endpoint string     // The name of the API end point (eg "query")
+	Form     url.Values // The form fields sent with the request
+	APIKey   string     // The API key sent with the request
+}
+
+// Server is a local stand in for the DBHub.io API, for testing code which uses a dbhub.Connection.  Responses are
+// set up for each API end point with Handle(), and requests to end points without one get a 404 response.  Use
+// Connection() to get a connection which talks to the server, and Close() when done with it.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []Request
+	responses map[string]response
+}
+
+// response holds the canned response given for an API end point
+type response struct {
+	status int
+	body   []byte
+}
+
+// NewServer starts a new Server
+func NewServer() *Server {
+	s := &Server{responses: make(map[string]response)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Connection returns a connection which sends its requests to the server
+func (s *Server) Connection() dbhub.Connection {
+	c, _ := dbhub.New("test-api-key")
+	c.ChangeServer(s.URL)
+	return c
+}
+
+// Handle sets the response given when the API end point (eg "query") is requested.  Strings and []byte bodies are sent
+// as they are, while anything else is sent as JSON.
+func (s *Server) Handle(endpoint string, status int, body interface{}) (err error) {
+	var b []byte
+	switch v := body.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		b, err = json.Marshal(v)
+		if err != nil {
+			return
+		}
+	}
+	s.mu.Lock()
+	s.responses[endpoint] = response{status: status, body: b}
+	s.mu.Unlock()
+	return
+}
+
+// Requests returns the requests received by the server so far, in the order they arrived
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// serveHTTP records each request, then replies with the response set up for its end point
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.ParseMultipartForm(32 << 20)
+	} else {
+		r.ParseForm()
+	}
+	req := Request{Endpoint: path.Base(r.URL.Path), Form: r.PostForm, APIKey: r.PostForm.Get("apikey")}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	resp, ok := s.responses[req.Endpoint]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error": "no response set up for the %s end point"}`, req.Endpoint)
+		return
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
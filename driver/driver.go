@@ -0,0 +1,236 @@
+// Package driver provides a database/sql driver for databases stored on DBHub.io.  Importing it registers the driver
+// under the name "dbhub":
+//
+//	import _ "github.com/sqlitebrowser/go-dbhub/driver"
+//
+//	db, err := sql.Open("dbhub", "apikey=YOUR_API_KEY_HERE;owner=justinclift;dbname=Join Testing.sqlite")
+//
+// The data source name holds semicolon separated key=value pairs.  apikey, owner, and dbname are required, while
+// server and apipath can be given to use a server other than the main DBHub.io one.  Queries (SELECT only) work with
+// any database.  Other statements go through the execute end point, so they only work with live databases.
+// Transactions aren't supported.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+)
+
+// ErrTransactionsNotSupported is returned when starting a transaction, as the DBHub.io API doesn't have them
+var ErrTransactionsNotSupported = errors.New("transactions aren't supported by the DBHub.io API")
+
+func init() {
+	sql.Register("dbhub", Driver{})
+}
+
+// Driver is the database/sql driver for DBHub.io
+type Driver struct{}
+
+// Open returns a new connection to a DBHub.io database, as given by the data source name.  No request is sent to the
+// server until the connection is used.
+func (Driver) Open(dsn string) (driver.Conn, error) {
+	cfg, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dbhub.New(cfg["apikey"])
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := cfg["server"]; ok {
+		c.ChangeServer(s)
+	}
+	if p, ok := cfg["apipath"]; ok {
+		c.SetAPIPath(p)
+	}
+	return &conn{c: c, dbOwner: cfg["owner"], dbName: cfg["dbname"]}, nil
+}
+
+// parseDSN splits a data source name into its key=value pairs, checking the required ones are present
+func parseDSN(dsn string) (cfg map[string]string, err error) {
+	cfg = make(map[string]string)
+	for _, part := range strings.Split(dsn, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid data source name entry '%s'", part)
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		switch key {
+		case "apikey", "owner", "dbname", "server", "apipath":
+		default:
+			return nil, fmt.Errorf("unknown data source name key '%s'", key)
+		}
+		cfg[key] = strings.TrimSpace(kv[1])
+	}
+	for _, key := range []string{"apikey", "owner", "dbname"} {
+		if cfg[key] == "" {
+			return nil, fmt.Errorf("no %s given in data source name", key)
+		}
+	}
+	return cfg, nil
+}
+
+// conn is a connection to a single DBHub.io database
+type conn struct {
+	c       dbhub.Connection
+	dbOwner string
+	dbName  string
+}
+
+// Begin returns an error, as transactions aren't supported
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, ErrTransactionsNotSupported
+}
+
+// Close does nothing, as the connection doesn't hold any resources between requests
+func (c *conn) Close() error {
+	return nil
+}
+
+// ExecContext runs a statement on the database, which needs to be a live database
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	q, err := bindNamedValues(query, args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.c.ExecuteResultContext(ctx, c.dbOwner, c.dbName, q)
+	if err != nil {
+		return nil, err
+	}
+	return result{rowsChanged: res.RowsChanged, lastInsertID: res.LastInsertID}, nil
+}
+
+// Prepare returns a statement for running later.  Nothing is sent to the server until then.
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+// QueryContext runs a query (SELECT only) on the database
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, err := bindNamedValues(query, args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.c.QueryTypedContext(ctx, c.dbOwner, c.dbName, dbhub.Identifier{}, q)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{res: res}, nil
+}
+
+// bindNamedValues places the arguments of a statement into it, with named arguments going to the matching named
+// placeholders
+func bindNamedValues(query string, args []driver.NamedValue) (string, error) {
+	values := make([]interface{}, 0, len(args))
+	for _, j := range args {
+		if j.Name != "" {
+			values = append(values, dbhub.Named(j.Name, j.Value))
+			continue
+		}
+		values = append(values, j.Value)
+	}
+	return dbhub.BindArgs(query, values...)
+}
+
+// stmt is a prepared statement
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+// Close does nothing, as statements aren't prepared on the server
+func (s *stmt) Close() error {
+	return nil
+}
+
+// Exec runs the statement with the given arguments
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+// ExecContext runs the statement with the given arguments
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+// NumInput returns -1, as the placeholders aren't counted ahead of time
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+// Query runs the statement with the given arguments
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+// QueryContext runs the statement with the given arguments
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+// namedValues converts a list of positional arguments to the form used by the context aware functions
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, j := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: j}
+	}
+	return named
+}
+
+// result holds the outcome of running a statement
+type result struct {
+	rowsChanged  int64
+	lastInsertID int64
+}
+
+// LastInsertId returns the rowid of the last row inserted by the statement
+func (r result) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+// RowsAffected returns the number of rows changed by the statement
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsChanged, nil
+}
+
+// rows iterates through the results of a query
+type rows struct {
+	res  dbhub.TypedResults
+	next int
+}
+
+// Close does nothing, as the results are already fully retrieved
+func (r *rows) Close() error {
+	return nil
+}
+
+// Columns returns the names of the columns in the results
+func (r *rows) Columns() []string {
+	return r.res.ColNames
+}
+
+// Next places the values of the next row into dest
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.res.Rows) {
+		return io.EOF
+	}
+	row := r.res.Rows[r.next]
+	r.next++
+	if len(row) != len(dest) {
+		return fmt.Errorf("row has %d fields, but there are %d columns", len(row), len(dest))
+	}
+	for i, j := range row {
+		dest[i] = j.Value
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package driver_test
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+	"github.com/sqlitebrowser/go-dbhub/driver"
+)
+
+// openDB opens the database "me/db.sqlite" on the test server through database/sql
+func openDB(t *testing.T, s *dbhubtest.Server) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("dbhub", "apikey=test-api-key;owner=me;dbname=db.sqlite;server="+s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// lastSQL returns the SQL sent with the most recent request received by the server
+func lastSQL(t *testing.T, s *dbhubtest.Server) string {
+	t.Helper()
+	reqs := s.Requests()
+	if len(reqs) == 0 {
+		t.Fatal("no requests sent")
+	}
+	b, err := base64.StdEncoding.DecodeString(reqs[len(reqs)-1].Form.Get("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestQuery(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("query", 200, `[[{"Name":"id","Type":4,"Value":1},{"Name":"name","Type":3,"Value":"one"}],`+
+		`[{"Name":"id","Type":4,"Value":2},{"Name":"name","Type":2,"Value":null}]]`)
+	db := openDB(t, s)
+
+	rows, err := db.Query("SELECT id, name FROM t WHERE id > ? AND kind = :kind", 0, sql.Named("kind", "a'b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id int64
+		var name sql.NullString
+		if err = rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name.String)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "one" || got[1] != "" {
+		t.Errorf("got names %q", got)
+	}
+	if q := lastSQL(t, s); q != "SELECT id, name FROM t WHERE id > 0 AND kind = 'a''b'" {
+		t.Errorf("sent SQL %s", q)
+	}
+}
+
+func TestExec(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("execute", 200, `{"rows_changed":2,"last_insert_id":7}`)
+	db := openDB(t, s)
+
+	stmt, err := db.Prepare("UPDATE t SET name = ? WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec("two", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("got %d rows affected, want 2", n)
+	}
+	if id, _ := res.LastInsertId(); id != 7 {
+		t.Errorf("got last insert id %d, want 7", id)
+	}
+	if q := lastSQL(t, s); q != "UPDATE t SET name = 'two' WHERE id = 2" {
+		t.Errorf("sent SQL %s", q)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	db := openDB(t, s)
+
+	_, err := db.Begin()
+	if !errors.Is(err, driver.ErrTransactionsNotSupported) {
+		t.Errorf("Begin() got error %v, want ErrTransactionsNotSupported", err)
+	}
+	_, err = db.Exec("DELETE FROM t WHERE id = ?")
+	if err == nil {
+		t.Error("missing argument not reported")
+	}
+	if n := len(s.Requests()); n != 0 {
+		t.Errorf("%d requests sent, want none", n)
+	}
+}
+
+func TestDataSourceNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		wantErr bool
+	}{
+		{"valid", "apikey=k;owner=me;dbname=db.sqlite", false},
+		{"spaces and case", " APIKey = k ; owner=me;dbname=Join Testing.sqlite;", false},
+		{"missing api key", "owner=me;dbname=db.sqlite", true},
+		{"missing database", "apikey=k;owner=me", true},
+		{"unknown key", "apikey=k;owner=me;dbname=db.sqlite;colour=blue", true},
+		{"not key value", "apikey=k;owner=me;dbname=db.sqlite;oops", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := driver.Driver{}.Open(tt.dsn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
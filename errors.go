@@ -0,0 +1,80 @@
+package dbhub
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCircuitOpen is returned instead of sending a request, when the circuit breaker of the connection has been
+	// tripped by repeated failures
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrDatabaseExists is returned when creating a database with the name of one which already exists
+	ErrDatabaseExists = errors.New("database already exists")
+
+	// ErrDatabaseLocked is returned when a live database is locked by someone else
+	ErrDatabaseLocked = errors.New("database is locked")
+
+	// ErrDatabaseProcessing is returned when a database can't be used yet, as the server is still processing it (eg
+	// just after uploading).  This is temporary, so the request can be tried again a bit later
+	ErrDatabaseProcessing = errors.New("database is still being processed")
+
+	// ErrLiveDatabase is returned when asking for the branches (or other version history) of a live database, as live
+	// databases are changed directly rather than through commits
+	ErrLiveDatabase = errors.New("live databases have no version history")
+
+	// ErrMergeConflict is returned when a merge is rejected by the server, due to conflicting changes
+	ErrMergeConflict = errors.New("merge conflict")
+
+	// ErrNotConfirmed is returned when deleting or renaming a database is cancelled by the ConfirmDestructive check of
+	// the connection
+	ErrNotConfirmed = errors.New("action not confirmed")
+
+	// ErrNotLiveDatabase is returned when trying to change a standard database with Execute(), as only live databases
+	// can be changed directly
+	ErrNotLiveDatabase = errors.New("not a live database")
+
+	// ErrNoRows is returned by functions expecting a query to return a row, when it doesn't return any
+	ErrNoRows = errors.New("no rows in result set")
+)
+
+// APIError is returned when the DBHub.io server responds to a request with an error status.  It holds the HTTP status
+// code of the response and the name of the API end point (eg "query"), along with the error message provided by the
+// server.  Use errors.As to retrieve it, eg to tell an invalid API key (401) apart from a missing database (404).
+type APIError struct {
+	Code     int
+	Endpoint string
+	Message  string
+
+	// err is the sentinel error (if any) the response corresponds to, eg ErrDatabaseLocked
+	err error
+}
+
+// Error returns the error message provided by the server
+func (e *APIError) Error() string {
+	if e.err != nil {
+		return e.err.Error() + ": " + e.Message
+	}
+	return e.Message
+}
+
+// Unwrap returns the sentinel error the response corresponds to, so errors.Is works with them
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// QuotaExceededError is returned (wrapped in an APIError) when a request is rejected because it would take the account
+// past its storage quota.  Limit and Usage are in bytes, and are 0 if the server didn't provide them.
+type QuotaExceededError struct {
+	Limit int64 `json:"limit"`
+	Usage int64 `json:"usage"`
+}
+
+// Error returns a description of the exceeded quota
+func (e *QuotaExceededError) Error() string {
+	if e.Limit == 0 {
+		return "storage quota exceeded"
+	}
+	return fmt.Sprintf("storage quota exceeded (%d of %d bytes used)", e.Usage, e.Limit)
+}
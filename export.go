@@ -0,0 +1,321 @@
+package dbhub
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// DownloadTableCSV writes the contents of a table to w in CSV format, with a header row holding the column names.  The
+// server side CSV export is used when available, otherwise the table is retrieved with a query and converted locally.
+// BLOB fields are base64 encoded in the locally converted output.
+func (c Connection) DownloadTableCSV(dbOwner, dbName, table string, w io.Writer) (err error) {
+	return c.DownloadTableCSVContext(context.Background(), dbOwner, dbName, table, w)
+}
+
+// DownloadTableCSVContext is like DownloadTableCSV, but uses the given context for the request
+func (c Connection) DownloadTableCSVContext(ctx context.Context, dbOwner, dbName, table string, w io.Writer) (err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("table", table)
+
+	// Try the server side export first
+	var resp *http.Response
+	queryUrl := c.apiURL("exportcsv")
+	resp, err = c.doRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		_, err = io.Copy(w, resp.Body)
+		return
+	case http.StatusNotFound, http.StatusNotImplemented:
+		// The server doesn't support exporting, so fall back to doing it locally
+	default:
+		err = responseError(resp)
+		return
+	}
+	return c.tableCSV(ctx, dbOwner, dbName, table, w)
+}
+
+// QueryCSV runs a SQL query (SELECT only) on the chosen database, writing the results to w in CSV format as they're
+// received.  The rows aren't held in memory, so this suits queries returning a very large number of rows.  BLOB
+// fields are base64 encoded, and NULLs are written as given by opts.  As the column names come with the rows, the
+// header row is only written when the query returns at least one row.
+func (c Connection) QueryCSV(dbOwner, dbName, sql string, w io.Writer, opts CSVOptions) (err error) {
+	return c.QueryCSVContext(context.Background(), dbOwner, dbName, sql, w, opts)
+}
+
+// QueryCSVContext is like QueryCSV, but uses the given context for the request
+func (c Connection) QueryCSVContext(ctx context.Context, dbOwner, dbName, sql string, w io.Writer, opts CSVOptions) (err error) {
+	cw := csv.NewWriter(w)
+	first := true
+	err = c.queryStreamRaw(ctx, dbOwner, dbName, Identifier{}, sql, 0, func(row com.DataRow) error {
+		if first && opts.Header {
+			header := make([]string, 0, len(row))
+			for _, j := range row {
+				header = append(header, j.Name)
+			}
+			if err := cw.Write(header); err != nil {
+				return err
+			}
+		}
+		first = false
+		fields := make([]string, 0, len(row))
+		for _, j := range row {
+			fields = append(fields, csvField(nativeValue(j), opts.Null))
+		}
+		return cw.Write(fields)
+	})
+	if err != nil {
+		return
+	}
+	cw.Flush()
+	err = cw.Error()
+	return
+}
+
+// QueryJSON runs a SQL query (SELECT only) on the chosen database, writing the results to w as they're received.  The
+// output is a JSON array holding an object for each row, with the fields in column order.  NULLs are written as JSON
+// null, numbers as JSON numbers, and BLOBs as base64 encoded strings.
+func (c Connection) QueryJSON(dbOwner, dbName, sql string, w io.Writer) (err error) {
+	return c.QueryJSONContext(context.Background(), dbOwner, dbName, sql, w)
+}
+
+// QueryJSONContext is like QueryJSON, but uses the given context for the request
+func (c Connection) QueryJSONContext(ctx context.Context, dbOwner, dbName, sql string, w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	jw := jsonRowWriter{w: bw}
+	err = c.queryStreamRaw(ctx, dbOwner, dbName, Identifier{}, sql, 0, func(row com.DataRow) error {
+		names := make([]string, 0, len(row))
+		values := make([]interface{}, 0, len(row))
+		for _, j := range row {
+			names = append(names, j.Name)
+			values = append(values, nativeValue(j))
+		}
+		return jw.write(names, values)
+	})
+	if err != nil {
+		return
+	}
+	err = jw.close()
+	if err != nil {
+		return
+	}
+	err = bw.Flush()
+	return
+}
+
+// WriteCSV writes the results to w in CSV format.  BLOB fields are written as given by the blobBase64 argument of the
+// query.  As the results hold NULLs as empty strings, opts.Null isn't used.
+func (r Results) WriteCSV(w io.Writer, opts CSVOptions) (err error) {
+	cw := csv.NewWriter(w)
+	if opts.Header {
+		err = cw.Write(r.ColNames)
+		if err != nil {
+			return
+		}
+	}
+	for _, row := range r.Rows {
+		err = cw.Write(row.Fields)
+		if err != nil {
+			return
+		}
+	}
+	cw.Flush()
+	err = cw.Error()
+	return
+}
+
+// WriteJSON writes the results to w as a JSON array holding an object for each row, with the fields in column order.
+// All values are written as strings, as that's how the results hold them.  Use TypedResults.WriteJSON() to keep NULLs
+// and numbers.
+func (r Results) WriteJSON(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	jw := jsonRowWriter{w: bw}
+	for i, row := range r.Rows {
+		if len(row.Fields) != len(r.ColNames) {
+			return fmt.Errorf("row %d has %d fields, but there are %d columns", i, len(row.Fields), len(r.ColNames))
+		}
+		values := make([]interface{}, 0, len(row.Fields))
+		for _, j := range row.Fields {
+			values = append(values, j)
+		}
+		err = jw.write(r.ColNames, values)
+		if err != nil {
+			return
+		}
+	}
+	err = jw.close()
+	if err != nil {
+		return
+	}
+	err = bw.Flush()
+	return
+}
+
+// WriteCSV writes the results to w in CSV format.  BLOB fields are base64 encoded, and NULLs are written as given by
+// opts.
+func (r TypedResults) WriteCSV(w io.Writer, opts CSVOptions) (err error) {
+	cw := csv.NewWriter(w)
+	if opts.Header {
+		err = cw.Write(r.ColNames)
+		if err != nil {
+			return
+		}
+	}
+	for _, row := range r.Rows {
+		fields := make([]string, 0, len(row))
+		for _, j := range row {
+			fields = append(fields, csvField(j.Value, opts.Null))
+		}
+		err = cw.Write(fields)
+		if err != nil {
+			return
+		}
+	}
+	cw.Flush()
+	err = cw.Error()
+	return
+}
+
+// WriteJSON writes the results to w as a JSON array holding an object for each row, with the fields in column order.
+// NULLs are written as JSON null, numbers as JSON numbers, and BLOBs as base64 encoded strings.
+func (r TypedResults) WriteJSON(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	jw := jsonRowWriter{w: bw}
+	for i, row := range r.Rows {
+		if len(row) != len(r.ColNames) {
+			return fmt.Errorf("row %d has %d fields, but there are %d columns", i, len(row), len(r.ColNames))
+		}
+		values := make([]interface{}, 0, len(row))
+		for _, j := range row {
+			values = append(values, j.Value)
+		}
+		err = jw.write(r.ColNames, values)
+		if err != nil {
+			return
+		}
+	}
+	err = jw.close()
+	if err != nil {
+		return
+	}
+	err = bw.Flush()
+	return
+}
+
+// csvField returns a native field value as CSV text.  BLOBs are base64 encoded, times are given in RFC 3339 format, and
+// NULLs are given as null
+func csvField(v interface{}, null string) string {
+	switch val := v.(type) {
+	case nil:
+		return null
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val)
+	case string:
+		return val
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonRowWriter writes rows as the objects of a JSON array, keeping the fields of each in column order
+type jsonRowWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// write adds a row to the JSON array
+func (j *jsonRowWriter) write(names []string, values []interface{}) (err error) {
+	sep := ",\n"
+	if !j.started {
+		sep = "[\n"
+		j.started = true
+	}
+	_, err = io.WriteString(j.w, sep+"{")
+	if err != nil {
+		return
+	}
+	for i, name := range names {
+		var k, v []byte
+		k, err = json.Marshal(name)
+		if err != nil {
+			return
+		}
+		v, err = json.Marshal(values[i])
+		if err != nil {
+			return
+		}
+		if i > 0 {
+			_, err = io.WriteString(j.w, ",")
+			if err != nil {
+				return
+			}
+		}
+		_, err = fmt.Fprintf(j.w, "%s:%s", k, v)
+		if err != nil {
+			return
+		}
+	}
+	_, err = io.WriteString(j.w, "}")
+	return
+}
+
+// close ends the JSON array
+func (j *jsonRowWriter) close() (err error) {
+	if !j.started {
+		_, err = io.WriteString(j.w, "[]\n")
+		return
+	}
+	_, err = io.WriteString(j.w, "\n]\n")
+	return
+}
+
+// tableCSV retrieves the contents of a table using a query, writing it to w in CSV format
+func (c Connection) tableCSV(ctx context.Context, dbOwner, dbName, table string, w io.Writer) (err error) {
+	// Retrieve the column names for the header row
+	columns, err := c.ColumnsContext(ctx, dbOwner, dbName, Identifier{}, table)
+	if err != nil {
+		return
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Cid < columns[j].Cid })
+	var header []string
+	for _, j := range columns {
+		header = append(header, j.Name)
+	}
+
+	// Retrieve the table data
+	res, err := c.QueryContext(ctx, dbOwner, dbName, Identifier{}, true, fmt.Sprintf("SELECT * FROM %s", quoteIdentifier(table)))
+	if err != nil {
+		return
+	}
+
+	// Write it out
+	cw := csv.NewWriter(w)
+	err = cw.Write(header)
+	if err != nil {
+		return
+	}
+	for _, row := range res.Rows {
+		err = cw.Write(row.Fields)
+		if err != nil {
+			return
+		}
+	}
+	cw.Flush()
+	err = cw.Error()
+	return
+}
@@ -0,0 +1,35 @@
+package dbhub_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+func TestTypedResultsWriteJSON(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("query", 200, `[[{"Name":"i","Type":4,"Value":1000000},{"Name":"f","Type":5,"Value":2.5},`+
+		`{"Name":"t","Type":3,"Value":"1"},{"Name":"e","Type":3,"Value":""},{"Name":"n","Type":2,"Value":null},`+
+		`{"Name":"b","Type":0,"Value":"ab"}]]`)
+	res, err := s.Connection().QueryTyped("me", "db.sqlite", dbhub.Identifier{}, "SELECT i, f, t, e, n, b FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf, got bytes.Buffer
+	if err = res.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Compact(&got, buf.Bytes()); err != nil {
+		t.Fatalf("invalid JSON written: %v\n%s", err, buf.String())
+	}
+
+	// Numbers stay numbers, text which looks like a number stays text, and NULL isn't confused with an empty string
+	want := `[{"i":1000000,"f":2.5,"t":"1","e":"","n":null,"b":"YWI="}]`
+	if got.String() != want {
+		t.Errorf("got %s, want %s", got.String(), want)
+	}
+}
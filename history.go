@@ -0,0 +1,347 @@
+package dbhub
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// BranchCommitCounts returns the number of commits on each branch of a database, keyed by branch name
+func (c Connection) BranchCommitCounts(dbOwner, dbName string) (counts map[string]int, err error) {
+	return c.BranchCommitCountsContext(context.Background(), dbOwner, dbName)
+}
+
+// BranchCommitCountsContext is like BranchCommitCounts, but uses the given context for the request
+func (c Connection) BranchCommitCountsContext(ctx context.Context, dbOwner, dbName string) (counts map[string]int, err error) {
+	branches, _, err := c.BranchesContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	counts = make(map[string]int, len(branches))
+	for name, j := range branches {
+		counts[name] = j.CommitCount
+	}
+	return
+}
+
+// CommitGraph returns the parent commit IDs of every commit in a database, keyed by commit ID.  The first parent
+// listed is the main parent, with merge commits having the merged in parent(s) after it.  The initial commit has no
+// parents.
+func (c Connection) CommitGraph(dbOwner, dbName string) (graph map[string][]string, err error) {
+	return c.CommitGraphContext(context.Background(), dbOwner, dbName)
+}
+
+// CommitGraphContext is like CommitGraph, but uses the given context for the request
+func (c Connection) CommitGraphContext(ctx context.Context, dbOwner, dbName string) (graph map[string][]string, err error) {
+	commits, err := c.CommitsContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	graph = make(map[string][]string, len(commits))
+	for id, j := range commits {
+		var parents []string
+		if j.Parent != "" {
+			parents = append(parents, j.Parent)
+		}
+		parents = append(parents, j.OtherParents...)
+		graph[id] = parents
+	}
+	return
+}
+
+// CommitSizeDelta returns the change in size (in bytes) of the database file from the parent of a commit to the commit
+// itself.  For the initial commit, the parent size is taken to be 0.  For merge commits the main parent is used.
+func (c Connection) CommitSizeDelta(dbOwner, dbName, commitID string) (delta int64, err error) {
+	return c.CommitSizeDeltaContext(context.Background(), dbOwner, dbName, commitID)
+}
+
+// CommitSizeDeltaContext is like CommitSizeDelta, but uses the given context for the request
+func (c Connection) CommitSizeDeltaContext(ctx context.Context, dbOwner, dbName, commitID string) (delta int64, err error) {
+	commits, err := c.CommitsContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	commit, ok := commits[commitID]
+	if !ok {
+		err = fmt.Errorf("commit '%s' not found", commitID)
+		return
+	}
+	var parentSize int64
+	if commit.Parent != "" {
+		parent, ok := commits[commit.Parent]
+		if !ok {
+			err = fmt.Errorf("parent commit '%s' not found", commit.Parent)
+			return
+		}
+		parentSize = commitDBSize(parent)
+	}
+	delta = commitDBSize(commit) - parentSize
+	return
+}
+
+// Contributors returns the authors of the commits to a database, along with the number of commits each made.  Authors
+// are told apart by email address (ignoring case), or by name if they have no email address.  The list is sorted by
+// number of commits, from most to least.
+func (c Connection) Contributors(dbOwner, dbName string) (contributors []Contributor, err error) {
+	return c.ContributorsContext(context.Background(), dbOwner, dbName)
+}
+
+// ContributorsContext is like Contributors, but uses the given context for the request
+func (c Connection) ContributorsContext(ctx context.Context, dbOwner, dbName string) (contributors []Contributor, err error) {
+	commits, err := c.CommitsContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	index := make(map[string]int)
+	for _, j := range commits {
+		key := "email:" + strings.ToLower(j.AuthorEmail)
+		if j.AuthorEmail == "" {
+			key = "name:" + j.AuthorName
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(contributors)
+			index[key] = i
+			contributors = append(contributors, Contributor{Name: j.AuthorName, Email: j.AuthorEmail})
+		}
+		contributors[i].Commits++
+	}
+
+	// Map iteration order is random, so contributors with the same number of commits are ordered by name then email
+	// to keep the output stable
+	sort.Slice(contributors, func(i, j int) bool {
+		a, b := contributors[i], contributors[j]
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Email < b.Email
+	})
+	return
+}
+
+// DatabasesModifiedSince returns the databases in your account which have had a commit made after the given time.
+// The DateEntry field of each returned entry holds the time of the most recent commit.  The server doesn't support
+// filtering by time, so the commits of each database are retrieved and checked.
+func (c Connection) DatabasesModifiedSince(since time.Time) (databases []com.DBEntry, err error) {
+	return c.DatabasesModifiedSinceContext(context.Background(), since)
+}
+
+// DatabasesModifiedSinceContext is like DatabasesModifiedSince, but uses the given context for the requests
+func (c Connection) DatabasesModifiedSinceContext(ctx context.Context, since time.Time) (databases []com.DBEntry, err error) {
+	names, err := c.DatabasesContext(ctx)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		var commits map[string]com.CommitEntry
+		commits, err = c.CommitsContext(ctx, "", name)
+		if err != nil {
+			err = fmt.Errorf("database '%s': %w", name, err)
+			return
+		}
+		var modified time.Time
+		for _, j := range commits {
+			if j.Timestamp.After(modified) {
+				modified = j.Timestamp
+			}
+		}
+		if modified.After(since) {
+			databases = append(databases, com.DBEntry{DBName: name, DateEntry: modified})
+		}
+	}
+	return
+}
+
+// DatabasesForUser returns the details of the databases owned by a user, including the visibility, default branch,
+// size, and last modified time of each.  If owner is empty, the databases in your own account are returned, otherwise
+// only the public databases of the user are.  Each database is looked up separately, so this can take a while for
+// users with many databases.
+func (c Connection) DatabasesForUser(owner string) (databases []DatabaseInfo, err error) {
+	return c.DatabasesForUserContext(context.Background(), owner)
+}
+
+// DatabasesForUserContext is like DatabasesForUser, but uses the given context for the requests
+func (c Connection) DatabasesForUserContext(ctx context.Context, owner string) (databases []DatabaseInfo, err error) {
+	names, err := c.databaseNames(ctx, owner)
+	if err != nil {
+		return
+	}
+
+	// The database list doesn't say which databases are public.  Other users only have their public ones listed, while
+	// for your own account the list is compared with that of its public databases, which needs the account name.  That
+	// is taken from the web page address of one of the databases
+	public := make(map[string]bool)
+	if owner == "" && len(names) != 0 {
+		var page com.WebpageResponseContainer
+		page, err = c.WebpageContext(ctx, "", names[0])
+		if err != nil {
+			return
+		}
+		owner, err = webpageOwner(page.WebPage)
+		if err != nil {
+			return
+		}
+		var publicNames []string
+		publicNames, err = c.databaseNames(ctx, owner)
+		if err != nil {
+			return
+		}
+		for _, j := range publicNames {
+			public[j] = true
+		}
+	} else {
+		for _, j := range names {
+			public[j] = true
+		}
+	}
+
+	// Retrieve the overview of each database
+	for _, name := range names {
+		info := DatabaseInfo{Owner: owner, Name: name, Public: public[name]}
+		info.DatabaseSummary, err = c.SummaryContext(ctx, owner, name)
+		if err != nil {
+			err = fmt.Errorf("database '%s': %w", name, err)
+			return
+		}
+		databases = append(databases, info)
+	}
+	return
+}
+
+// webpageOwner returns the name of the owner of a database, from the address of its web page (eg
+// "https://dbhub.io/justinclift/Join Testing.sqlite")
+func webpageOwner(webPage string) (owner string, err error) {
+	u, err := url.Parse(webPage)
+	if err != nil {
+		return
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[len(parts)-2] == "" {
+		err = fmt.Errorf("unexpected database web page address '%s'", webPage)
+		return
+	}
+	owner = parts[len(parts)-2]
+	return
+}
+
+// Summary returns an overview of a database: its default branch, along with the number of commits on it and the size
+// of the database at its head, plus the number of branches, tags, and releases
+func (c Connection) Summary(dbOwner, dbName string) (summary DatabaseSummary, err error) {
+	return c.SummaryContext(context.Background(), dbOwner, dbName)
+}
+
+// SummaryContext is like Summary, but uses the given context for the request
+func (c Connection) SummaryContext(ctx context.Context, dbOwner, dbName string) (summary DatabaseSummary, err error) {
+	meta, err := c.MetadataContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	head, ok := meta.Branches[meta.DefBranch]
+	if !ok {
+		err = fmt.Errorf("default branch '%s' not found", meta.DefBranch)
+		return
+	}
+	summary = DatabaseSummary{
+		DefaultBranch: meta.DefBranch,
+		HeadCommit:    head.Commit,
+		CommitCount:   head.CommitCount,
+		Branches:      len(meta.Branches),
+		Tags:          len(meta.Tags),
+		Releases:      len(meta.Releases),
+	}
+	if commit, ok := meta.Commits[head.Commit]; ok {
+		summary.Size = commitDBSize(commit)
+		summary.LastModified = commit.Timestamp
+	}
+	return
+}
+
+// Timeline returns the commits, tags, and releases of a database combined into a single list, sorted by date from
+// oldest to newest
+func (c Connection) Timeline(dbOwner, dbName string) (timeline []TimelineEntry, err error) {
+	return c.TimelineContext(context.Background(), dbOwner, dbName)
+}
+
+// TimelineContext is like Timeline, but uses the given context for the request
+func (c Connection) TimelineContext(ctx context.Context, dbOwner, dbName string) (timeline []TimelineEntry, err error) {
+	meta, err := c.MetadataContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	for id, j := range meta.Commits {
+		timeline = append(timeline, TimelineEntry{
+			Kind:        TimelineCommit,
+			Name:        id,
+			Commit:      id,
+			Date:        j.Timestamp,
+			Description: j.Message,
+			AuthorName:  j.AuthorName,
+			AuthorEmail: j.AuthorEmail,
+		})
+	}
+	for name, j := range meta.Tags {
+		timeline = append(timeline, TimelineEntry{
+			Kind:        TimelineTag,
+			Name:        name,
+			Commit:      j.Commit,
+			Date:        j.Date,
+			Description: j.Description,
+			AuthorName:  j.TaggerName,
+			AuthorEmail: j.TaggerEmail,
+		})
+	}
+	for name, j := range meta.Releases {
+		timeline = append(timeline, TimelineEntry{
+			Kind:        TimelineRelease,
+			Name:        name,
+			Commit:      j.Commit,
+			Date:        j.Date,
+			Description: j.Description,
+			AuthorName:  j.ReleaserName,
+			AuthorEmail: j.ReleaserEmail,
+		})
+	}
+
+	// Map iteration order is random, so entries with the same date are ordered by kind then name to keep the output
+	// stable
+	sort.Slice(timeline, func(i, j int) bool {
+		a, b := timeline[i], timeline[j]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return
+}
+
+// commitDBHash returns the SHA256 hash of the database file in a commit, or an empty string if the commit tree doesn't
+// contain one
+func commitDBHash(commit com.CommitEntry) string {
+	for _, j := range commit.Tree.Entries {
+		if j.EntryType == com.DATABASE {
+			return j.Sha256
+		}
+	}
+	return ""
+}
+
+// commitDBSize returns the size of the database file in a commit, or 0 if the commit tree doesn't contain one
+func commitDBSize(commit com.CommitEntry) int64 {
+	for _, j := range commit.Tree.Entries {
+		if j.EntryType == com.DATABASE {
+			return j.Size
+		}
+	}
+	return 0
+}
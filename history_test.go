@@ -0,0 +1,65 @@
+package dbhub_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+func TestDatabasesForUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		owner      string
+		wantOwner  string
+		wantPublic map[string]bool
+	}{
+		{"own account", "", "me", map[string]bool{"a.sqlite": true, "b.sqlite": false}},
+		{"other user", "someone", "someone", map[string]bool{"a.sqlite": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Your own account has a private database "b.sqlite", which isn't listed for other users
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				switch path.Base(r.URL.Path) {
+				case "databases":
+					if r.PostForm.Get("dbowner") == "" {
+						fmt.Fprint(w, `["a.sqlite","b.sqlite"]`)
+					} else {
+						fmt.Fprint(w, `["a.sqlite"]`)
+					}
+				case "webpage":
+					fmt.Fprintf(w, `{"web_page":"https://dbhub.io/me/%s"}`, r.PostForm.Get("dbname"))
+				case "metadata":
+					if owner := r.PostForm.Get("dbowner"); owner != tt.wantOwner {
+						t.Errorf("metadata requested for owner %q, want %q", owner, tt.wantOwner)
+					}
+					fmt.Fprint(w, `{"branches":{"main":{"commit":"c1","commit_count":3}},"default_branch":"main",`+
+						`"commits":{"c1":{"id":"c1"}}}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer s.Close()
+
+			databases, err := newConnection(t, s).DatabasesForUser(tt.owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(databases) != len(tt.wantPublic) {
+				t.Fatalf("got %d databases, want %d", len(databases), len(tt.wantPublic))
+			}
+			for _, j := range databases {
+				public, ok := tt.wantPublic[j.Name]
+				if !ok || j.Public != public || j.Owner != tt.wantOwner {
+					t.Errorf("got database %s owned by %q with public %v", j.Name, j.Owner, j.Public)
+				}
+				if j.DefaultBranch != "main" || j.CommitCount != 3 {
+					t.Errorf("got summary %+v for %s", j.DatabaseSummary, j.Name)
+				}
+			}
+		})
+	}
+}
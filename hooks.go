@@ -0,0 +1,77 @@
+package dbhub
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// send sends a request using the HTTP client of the connection, reporting it to the OnRequest hook (if any)
+func (c Connection) send(req *http.Request, attempt int) (resp *http.Response, err error) {
+	if c.OnRequest == nil {
+		return c.httpClient().Do(req)
+	}
+
+	// Count the bytes sent
+	reqBody := &countingReadCloser{}
+	if req.Body != nil {
+		reqBody.ReadCloser = req.Body
+		req.Body = reqBody
+	}
+	start := time.Now()
+	resp, err = c.httpClient().Do(req)
+	info := RequestInfo{Endpoint: endpointName(req.URL.String()), Attempt: attempt}
+	if err != nil {
+		info.Duration = time.Since(start)
+		info.RequestSize = reqBody.count()
+		info.Err = err
+		c.OnRequest(req.Context(), info)
+		return
+	}
+
+	// Report the request once the caller is done with the response
+	info.StatusCode = resp.StatusCode
+	var once sync.Once
+	respBody := &countingReadCloser{ReadCloser: resp.Body}
+	respBody.onClose = func() {
+		once.Do(func() {
+			info.Duration = time.Since(start)
+			info.RequestSize = reqBody.count()
+			info.ResponseSize = respBody.count()
+			c.OnRequest(req.Context(), info)
+		})
+	}
+	resp.Body = respBody
+	return
+}
+
+// countingReadCloser counts the bytes read through it, optionally calling a function when it's closed
+type countingReadCloser struct {
+	io.ReadCloser
+	n       int64
+	onClose func()
+}
+
+// Read reads from the wrapped reader, counting the bytes read
+func (r *countingReadCloser) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return
+}
+
+// count returns the number of bytes read so far.  The body of a request is read by the HTTP client in the background,
+// so this is safe to call while reading is still going on
+func (r *countingReadCloser) count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
+
+// Close closes the wrapped reader, then calls the close function
+func (r *countingReadCloser) Close() (err error) {
+	err = r.ReadCloser.Close()
+	if r.onClose != nil {
+		r.onClose()
+	}
+	return
+}
@@ -2,39 +2,51 @@ package dbhub
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
+	"time"
 )
 
+// apiURL returns the URL of an API end point on the server (eg "query")
+func (c Connection) apiURL(endpoint string) string {
+	p := c.APIPath
+	if p == "" {
+		p = defaultAPIPath
+	}
+	p = strings.Trim(p, "/")
+	if p != "" {
+		p = "/" + p
+	}
+	return strings.TrimSuffix(c.Server, "/") + p + "/" + endpoint
+}
+
 // sendRequestJSON sends a request to DBHub.io, formatting the returned result as JSON
-func sendRequestJSON(queryUrl string, data url.Values, returnStructure interface{}) (err error) {
+func (c Connection) sendRequestJSON(ctx context.Context, queryUrl string, data url.Values, returnStructure interface{}) (err error) {
+	// Use the cache for the end points it applies to
+	if returnStructure != nil && c.Cache != nil && cacheable[endpointName(queryUrl)] {
+		return c.sendRequestCached(ctx, queryUrl, data, returnStructure)
+	}
+
 	// Send the request
 	var body io.ReadCloser
-	body, err = sendRequest(queryUrl, data)
-	if body != nil {
-		defer body.Close()
-	}
+	body, err = c.sendRequest(ctx, queryUrl, data)
 	if err != nil {
-		if body != nil {
-			// If there's useful error info in the returned JSON, return that as the error message
-			var z JSONError
-			err = json.NewDecoder(body).Decode(&z)
-			if err != nil {
-				return
-			}
-			err = fmt.Errorf("%s", z.Msg)
-		}
 		return
 	}
+	defer body.Close()
 
 	// Unmarshall the JSON response into the structure provided by the caller
 	if returnStructure != nil {
-		err = json.NewDecoder(body).Decode(returnStructure)
+		_, err = decodeResponse(queryUrl, body, returnStructure)
+		err = contextError(ctx, queryUrl, err)
 		if err != nil {
 			return
 		}
@@ -42,58 +54,244 @@ func sendRequestJSON(queryUrl string, data url.Values, returnStructure interface
 	return
 }
 
+// decodeResponse decodes a JSON response into the structure provided by the caller.  Responses can either hold the
+// requested data directly, or be wrapped in an object holding it in a "data" field, with an optional "meta" field.
+// For wrapped responses the meta field is returned.  Decoding errors are wrapped with the name of the API end point.
+func decodeResponse(queryUrl string, body io.Reader, returnStructure interface{}) (meta json.RawMessage, err error) {
+	var raw json.RawMessage
+	err = json.NewDecoder(body).Decode(&raw)
+	if err == nil {
+		var data json.RawMessage
+		data, meta = unwrapResponse(raw)
+		err = json.Unmarshal(data, returnStructure)
+	}
+	if err != nil {
+		err = fmt.Errorf("decoding %s response: %w", endpointName(queryUrl), err)
+	}
+	return
+}
+
+// contextError returns an error wrapping the context error instead of err, if the context ended while reading the
+// response from an end point.  This stops a response cut short by the context being reported as invalid JSON.
+func contextError(ctx context.Context, queryUrl string, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("reading %s response: %w", endpointName(queryUrl), ctx.Err())
+	}
+	return err
+}
+
+// endpointName returns the name of the API end point a URL is for (eg "query")
+func endpointName(queryUrl string) string {
+	u, err := url.Parse(queryUrl)
+	if err != nil {
+		return queryUrl
+	}
+	return path.Base(u.Path)
+}
+
+// unwrapResponse extracts the data and meta fields from a wrapped JSON response.  Responses which aren't wrapped are
+// returned unchanged
+func unwrapResponse(raw json.RawMessage) (data, meta json.RawMessage) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return raw, nil
+	}
+
+	// Wrapped responses are objects with a "data" field, and nothing other than a "meta" field beside it
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(trimmed, &fields) != nil {
+		return raw, nil
+	}
+	d, ok := fields["data"]
+	if !ok {
+		return raw, nil
+	}
+	for k := range fields {
+		if k != "data" && k != "meta" {
+			return raw, nil
+		}
+	}
+	return d, fields["meta"]
+}
+
 // sendRequest sends a request to DBHub.io.  It exists because http.PostForm() doesn't seem to have a way of changing
 // header values.
-func sendRequest(queryUrl string, data url.Values) (body io.ReadCloser, err error) {
-	var req *http.Request
+func (c Connection) sendRequest(ctx context.Context, queryUrl string, data url.Values) (body io.ReadCloser, err error) {
 	var resp *http.Response
-	var client http.Client
-	req, err = http.NewRequest(http.MethodPost, queryUrl, strings.NewReader(data.Encode()))
+	resp, err = c.doRequest(ctx, queryUrl, data)
 	if err != nil {
 		return
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("User-Agent", fmt.Sprintf("go-dbhub v%s", version))
-	resp, err = client.Do(req)
-	if err != nil {
+
+	// Basic error handling, based on the status code received from the server
+	if resp.StatusCode != 200 {
+		// The returned status code indicates something went wrong.  If there's useful error info in the returned
+		// JSON, return that as the error message
+		err = responseError(resp)
+		resp.Body.Close()
 		return
 	}
-
-	// Return the response body, even if an error occurred.  This lets us return useful error information provided as
-	// JSON in the body of the message
 	body = resp.Body
+	return
+}
 
-	// Basic error handling, based on the status code received from the server
-	if resp.StatusCode != 200 {
-		// The returned status code indicates something went wrong
-		err = fmt.Errorf(resp.Status)
+// doRequest sends a request to DBHub.io, returning the response without looking at its status code.  Failed requests
+// are retried as allowed by the retry policy of the connection
+func (c Connection) doRequest(ctx context.Context, queryUrl string, data url.Values) (resp *http.Response, err error) {
+	return c.doRetried(ctx, queryUrl, c.maxRetries(ctx, queryUrl), func(key string) (*http.Request, error) {
+		return newRequest(ctx, queryUrl, data, key)
+	})
+}
+
+// doRetried sends the request constructed by newReq, retrying it up to the given number of times as allowed by the
+// retry policy and circuit breaker of the connection.  A fresh request is constructed for each attempt, given the
+// idempotency key (if any) to send.
+func (c Connection) doRetried(ctx context.Context, queryUrl string, retries int, newReq func(key string) (*http.Request, error)) (resp *http.Response, err error) {
+	key := idempotencyKey(ctx)
+	for attempt := 0; ; attempt++ {
+		err = c.Breaker.allow()
+		if err != nil {
+			return
+		}
+		resp, err = c.doRequestOnce(newReq, key, attempt)
+		if ctx.Err() != nil {
+			c.Breaker.release()
+		} else {
+			c.Breaker.record(serverFailed(resp, err))
+		}
+		if attempt >= retries || !shouldRetry(resp, err) || ctx.Err() != nil {
+			return
+		}
+
+		// Discard the failed response, then wait a bit before trying again
+		wait := c.Retry.wait(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+			resp = nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// doRequestOnce makes a single attempt at sending a request to DBHub.io
+func (c Connection) doRequestOnce(newReq func(key string) (*http.Request, error), key string, attempt int) (resp *http.Response, err error) {
+	var req *http.Request
+	req, err = newReq(key)
+	if err != nil {
 		return
 	}
+	resp, err = c.send(req, attempt)
 	return
 }
 
-// sendUpload uploads a database to DBHub.io.  It exists because the DBHub.io upload end point requires multi-part data
-func sendUpload(queryUrl string, data *url.Values, dbBytes *[]byte) (body io.ReadCloser, err error) {
-	// Prepare the database file byte stream
-	var buf bytes.Buffer
-	w := multipart.NewWriter(&buf)
-	dbName := data.Get("dbname")
-	var wri io.Writer
-	if dbName != "" {
-		wri, err = w.CreateFormFile("file", dbName)
-	} else {
-		wri, err = w.CreateFormFile("file", "database.db")
+// httpClient returns the HTTP client to send requests with
+func (c Connection) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
 	}
+	return &http.Client{}
+}
+
+// newRequest constructs a request for sending to DBHub.io
+func newRequest(ctx context.Context, queryUrl string, data url.Values, key string) (req *http.Request, err error) {
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, queryUrl, strings.NewReader(data.Encode()))
 	if err != nil {
 		return
 	}
-	_, err = wri.Write(*dbBytes)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", fmt.Sprintf("go-dbhub v%s", version))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	return
+}
+
+// responseError returns an APIError holding the status code and the error message provided as JSON in the body of an
+// unsuccessful response, falling back to the response status if there isn't one.  Responses saying the database is
+// still being processed, or is locked, wrap ErrDatabaseProcessing or ErrDatabaseLocked.  Responses saying the storage
+// quota has been exceeded wrap a QuotaExceededError.  Those with a "not_live" status wrap ErrNotLiveDatabase, while those
+// with a "live" status wrap ErrLiveDatabase.
+func responseError(resp *http.Response) *APIError {
+	var z JSONError
+	var quota QuotaExceededError
+	body, _ := ioutil.ReadAll(resp.Body)
+	if json.Unmarshal(body, &z) != nil || z.Msg == "" {
+		z.Msg = resp.Status
+	}
+	json.Unmarshal(body, &quota)
+	e := &APIError{Code: resp.StatusCode, Message: z.Msg}
+	if resp.Request != nil && resp.Request.URL != nil {
+		e.Endpoint = path.Base(resp.Request.URL.Path)
+	}
+	if resp.StatusCode == http.StatusTooEarly || z.Status == "processing" {
+		e.err = ErrDatabaseProcessing
+	} else if resp.StatusCode == http.StatusLocked || strings.Contains(z.Msg, "database is locked") {
+		e.err = ErrDatabaseLocked
+	} else if resp.StatusCode == http.StatusInsufficientStorage || z.Status == "quota_exceeded" || quota.Limit > 0 {
+		e.err = &quota
+	} else if z.Status == "not_live" {
+		e.err = ErrNotLiveDatabase
+	} else if z.Status == "live" {
+		e.err = ErrLiveDatabase
+	}
+	return e
+}
+
+// sendUpload uploads a database to DBHub.io.  It exists because the DBHub.io upload end point requires multi-part data.
+// The database file is streamed from the reader as the request is sent, rather than being held in memory first.
+func (c Connection) sendUpload(ctx context.Context, queryUrl string, data url.Values, db io.Reader) (body io.ReadCloser, err error) {
+	// Upload the database.  It goes through the circuit breaker and OnRequest hook like other requests, but is never
+	// retried as the database is streamed from the reader
+	var pr *io.PipeReader
+	var resp *http.Response
+	resp, err = c.doRetried(ctx, queryUrl, 0, func(key string) (req *http.Request, err error) {
+		// Prepare the multi-part byte stream, with the fields going first so the server has them before the database
+		var pw *io.PipeWriter
+		pr, pw = io.Pipe()
+		w := multipart.NewWriter(pw)
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, queryUrl, pr)
+		if err != nil {
+			return
+		}
+		go func() {
+			pw.CloseWithError(writeUpload(w, data, db))
+		}()
+		req.Header.Set("User-Agent", fmt.Sprintf("go-dbhub v%s", version))
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		return
+	})
+	if pr != nil {
+		defer pr.Close() // Stops the writer if the server responds without reading the whole upload
+	}
 	if err != nil {
 		return
 	}
 
+	// Basic error handling, based on the status code received from the server
+	if resp.StatusCode != 201 {
+		// The returned status code indicates something went wrong.  If there's useful error info in the returned
+		// JSON, return that as the error message
+		err = responseError(resp)
+		resp.Body.Close()
+		return
+	}
+	body = resp.Body
+	return
+}
+
+// writeUpload writes the fields and database file of an upload as multi-part data
+func writeUpload(w *multipart.Writer, data url.Values, db io.Reader) (err error) {
 	// Add the headers
-	for i, j := range *data {
+	var wri io.Writer
+	for i, j := range data {
 		wri, err = w.CreateFormField(i)
 		if err != nil {
 			return
@@ -103,37 +301,19 @@ func sendUpload(queryUrl string, data *url.Values, dbBytes *[]byte) (body io.Rea
 			return
 		}
 	}
-	err = w.Close()
-	if err != nil {
-		return
-	}
 
-	// Prepare the request
-	var req *http.Request
-	var resp *http.Response
-	var client http.Client
-	req, err = http.NewRequest(http.MethodPost, queryUrl, &buf)
-	if err != nil {
-		return
+	// Add the database file
+	dbName := data.Get("dbname")
+	if dbName == "" {
+		dbName = "database.db"
 	}
-	req.Header.Set("User-Agent", fmt.Sprintf("go-dbhub v%s", version))
-	req.Header.Set("Content-Type", w.FormDataContentType())
-
-	// Upload the database
-	resp, err = client.Do(req)
+	wri, err = w.CreateFormFile("file", dbName)
 	if err != nil {
 		return
 	}
-
-	// Return the response body, even if an error occurred.  This lets us return useful error information provided as
-	// JSON in the body of the message
-	body = resp.Body
-
-	// Basic error handling, based on the status code received from the server
-	if resp.StatusCode != 201 {
-		// The returned status code indicates something went wrong
-		err = fmt.Errorf(resp.Status)
+	_, err = io.Copy(wri, db)
+	if err != nil {
 		return
 	}
-	return
+	return w.Close()
 }
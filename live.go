@@ -0,0 +1,182 @@
+package dbhub
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// optimizeTimeout is how long Optimize() waits for the database maintenance to finish
+const optimizeTimeout = 10 * time.Minute
+
+// CreateIndex creates an index on one or more columns of a table in a live database
+func (c Connection) CreateIndex(dbOwner, dbName, indexName, table string, columns []string, unique bool) (err error) {
+	return c.CreateIndexContext(context.Background(), dbOwner, dbName, indexName, table, columns, unique)
+}
+
+// CreateIndexContext is like CreateIndex, but uses the given context for the request
+func (c Connection) CreateIndexContext(ctx context.Context, dbOwner, dbName, indexName, table string, columns []string, unique bool) (err error) {
+	if indexName == "" {
+		err = fmt.Errorf("no index name given")
+		return
+	}
+	if table == "" {
+		err = fmt.Errorf("no table name given")
+		return
+	}
+	if len(columns) == 0 {
+		err = fmt.Errorf("no columns given for index '%s'", indexName)
+		return
+	}
+	for _, j := range columns {
+		if j == "" {
+			err = fmt.Errorf("empty column name given for index '%s'", indexName)
+			return
+		}
+	}
+	kind := "INDEX"
+	if unique {
+		kind = "UNIQUE INDEX"
+	}
+	_, err = c.ExecuteContext(ctx, dbOwner, dbName, fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, quoteIdentifier(indexName),
+		quoteIdentifier(table), quoteIdentifiers(columns)))
+	return
+}
+
+// DropIndex removes an index from a live database.  It's not an error if the index doesn't exist
+func (c Connection) DropIndex(dbOwner, dbName, indexName string) (err error) {
+	return c.DropIndexContext(context.Background(), dbOwner, dbName, indexName)
+}
+
+// DropIndexContext is like DropIndex, but uses the given context for the request
+func (c Connection) DropIndexContext(ctx context.Context, dbOwner, dbName, indexName string) (err error) {
+	if indexName == "" {
+		err = fmt.Errorf("no index name given")
+		return
+	}
+	_, err = c.ExecuteContext(ctx, dbOwner, dbName, "DROP INDEX IF EXISTS "+quoteIdentifier(indexName))
+	return
+}
+
+// ExecScript runs a SQL script containing one or more statements on a live database.  With the SplitStatements option
+// set, the script is split into individual statements which are run one after the other, stopping at the first one
+// that fails.  A result is returned for each statement run successfully.  Otherwise the whole script is sent to the
+// server in one go, giving a single result.
+func (c Connection) ExecScript(dbOwner, dbName, script string, opts ExecScriptOptions) (results []ExecResult, err error) {
+	return c.ExecScriptContext(context.Background(), dbOwner, dbName, script, opts)
+}
+
+// ExecScriptContext is like ExecScript, but uses the given context for the requests
+func (c Connection) ExecScriptContext(ctx context.Context, dbOwner, dbName, script string, opts ExecScriptOptions) (results []ExecResult, err error) {
+	stmts := []string{script}
+	if opts.SplitStatements {
+		stmts = splitStatements(script)
+	}
+	for i, sql := range stmts {
+		var res ExecResult
+		res, err = c.ExecuteResultContext(ctx, dbOwner, dbName, sql)
+		if err != nil {
+			if opts.SplitStatements {
+				err = fmt.Errorf("statement %d: %w", i+1, err)
+			}
+			return
+		}
+		results = append(results, res)
+	}
+	return
+}
+
+// Optimize runs database maintenance (VACUUM, then PRAGMA optimize) on a live database.  This can take a while for
+// large databases, so up to 10 minutes is allowed for it to finish.  If the database is in use by someone else, the
+// error returned wraps ErrDatabaseLocked.
+func (c Connection) Optimize(dbOwner, dbName string) (err error) {
+	return c.OptimizeContext(context.Background(), dbOwner, dbName)
+}
+
+// OptimizeContext is like Optimize, but uses the given context for the requests
+func (c Connection) OptimizeContext(ctx context.Context, dbOwner, dbName string) (err error) {
+	// Make sure the time limit of the HTTP client doesn't cut the maintenance short
+	if c.HTTPClient != nil && c.HTTPClient.Timeout != 0 && c.HTTPClient.Timeout < optimizeTimeout {
+		client := *c.HTTPClient
+		client.Timeout = optimizeTimeout
+		c.HTTPClient = &client
+	}
+	ctx, cancel := context.WithTimeout(ctx, optimizeTimeout)
+	defer cancel()
+	for _, sql := range []string{"VACUUM", "PRAGMA optimize"} {
+		_, err = c.ExecuteContext(ctx, dbOwner, dbName, sql)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Upsert inserts rows into a table of a live database, updating the existing row instead when one with the same
+// primary key is already present.  Each row must hold one value per entry in columns.  The total number of rows
+// changed is returned.
+func (c Connection) Upsert(dbOwner, dbName, table string, columns []string, rows [][]interface{}) (rowsChanged int64, err error) {
+	return c.UpsertContext(context.Background(), dbOwner, dbName, table, columns, rows)
+}
+
+// UpsertContext is like Upsert, but uses the given context for the requests
+func (c Connection) UpsertContext(ctx context.Context, dbOwner, dbName, table string, columns []string, rows [][]interface{}) (rowsChanged int64, err error) {
+	if len(columns) == 0 {
+		err = fmt.Errorf("no columns given")
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	// The conflict target for the upsert is the primary key of the table
+	pk, err := c.PrimaryKeyContext(ctx, dbOwner, dbName, table)
+	if err != nil {
+		return
+	}
+	if len(pk) == 0 {
+		err = fmt.Errorf("table '%s' has no primary key", table)
+		return
+	}
+
+	// Construct the list of row values
+	var values []string
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			err = fmt.Errorf("row %d has %d values, but %d columns were given", i, len(row), len(columns))
+			return
+		}
+		var fields []string
+		for _, j := range row {
+			var v string
+			v, err = quoteValue(j)
+			if err != nil {
+				return
+			}
+			fields = append(fields, v)
+		}
+		values = append(values, "("+strings.Join(fields, ", ")+")")
+	}
+
+	// Non primary key columns are updated from the incoming row on conflict
+	isPk := make(map[string]bool)
+	for _, j := range pk {
+		isPk[j] = true
+	}
+	var updates []string
+	for _, j := range columns {
+		if !isPk[j] {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", quoteIdentifier(j), quoteIdentifier(j)))
+		}
+	}
+	action := "DO NOTHING"
+	if len(updates) != 0 {
+		action = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+
+	// Run the upsert
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON CONFLICT (%s) %s", quoteIdentifier(table),
+		quoteIdentifiers(columns), strings.Join(values, ", "), quoteIdentifiers(pk), action)
+	return c.ExecuteContext(ctx, dbOwner, dbName, sql)
+}
@@ -0,0 +1,155 @@
+package dbhub_test
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+// executedSQL returns the SQL sent with each execute request received by the server
+func executedSQL(t *testing.T, s *dbhubtest.Server) (sql []string) {
+	t.Helper()
+	for _, req := range s.Requests() {
+		if req.Endpoint != "execute" {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(req.Form.Get("sql"))
+		if err != nil {
+			t.Fatalf("decoding the SQL of a statement: %v", err)
+		}
+		sql = append(sql, string(b))
+	}
+	return
+}
+
+func TestExecScriptSplitsStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"simple", "CREATE TABLE t (a);\nINSERT INTO t VALUES (1);", []string{"CREATE TABLE t (a)",
+			"INSERT INTO t VALUES (1)"}},
+		{"empty statements", ";; INSERT INTO t VALUES (1);;\n;", []string{"INSERT INTO t VALUES (1)"}},
+		{"no trailing semicolon", "DELETE FROM t; DELETE FROM u", []string{"DELETE FROM t", "DELETE FROM u"}},
+		{"semicolon in string", "INSERT INTO t VALUES ('a;b'); INSERT INTO t VALUES ('it''s;')",
+			[]string{"INSERT INTO t VALUES ('a;b')", "INSERT INTO t VALUES ('it''s;')"}},
+		{"semicolon in quoted identifier", `UPDATE "a;b" SET [c;d] = 1; DELETE FROM t`,
+			[]string{`UPDATE "a;b" SET [c;d] = 1`, "DELETE FROM t"}},
+		{"semicolon in line comment", "DELETE FROM t -- first; really\n; DELETE FROM u",
+			[]string{"DELETE FROM t -- first; really", "DELETE FROM u"}},
+		{"semicolon in block comment", "DELETE FROM t /* a; b */; DELETE FROM u",
+			[]string{"DELETE FROM t /* a; b */", "DELETE FROM u"}},
+		{"comment only", "DELETE FROM t; -- done;", []string{"DELETE FROM t"}},
+		{"trigger body", "CREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  UPDATE u SET n = n + 1;\n  " +
+			"INSERT INTO log VALUES ('added;');\nEND;\nINSERT INTO t VALUES (1);",
+			[]string{"CREATE TRIGGER tr AFTER INSERT ON t BEGIN\n  UPDATE u SET n = n + 1;\n  " +
+				"INSERT INTO log VALUES ('added;');\nEND", "INSERT INTO t VALUES (1)"}},
+		{"temporary trigger with case", "CREATE TEMP TRIGGER tr AFTER UPDATE ON t BEGIN UPDATE u SET n = " +
+			"CASE WHEN n > 0 THEN 1 ELSE 0 END; END; DELETE FROM t",
+			[]string{"CREATE TEMP TRIGGER tr AFTER UPDATE ON t BEGIN UPDATE u SET n = CASE WHEN n > 0 THEN 1 " +
+				"ELSE 0 END; END", "DELETE FROM t"}},
+		{"transaction", "BEGIN; DELETE FROM t; END;", []string{"BEGIN", "DELETE FROM t", "END"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("execute", 200, `{"rows_changed":1}`)
+			res, err := s.Connection().ExecScript("me", "live.sqlite", tt.script,
+				dbhub.ExecScriptOptions{SplitStatements: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := executedSQL(t, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ran statements %q, want %q", got, tt.want)
+			}
+			if len(res) != len(tt.want) {
+				t.Errorf("got %d results, want %d", len(res), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestExecScriptWhole(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("execute", 200, `{"rows_changed":2}`)
+	script := "DELETE FROM t; DELETE FROM u;"
+	_, err := s.Connection().ExecScript("me", "live.sqlite", script, dbhub.ExecScriptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := executedSQL(t, s); len(got) != 1 || got[0] != script {
+		t.Errorf("ran statements %q, want the whole script", got)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns string
+		cols    []string
+		rows    [][]interface{}
+		wantSQL string
+	}{
+		{"insert or update", `[{"column_id":0,"name":"id","primary_key":1},{"column_id":1,"name":"name","primary_key":0}]`,
+			[]string{"id", "name"}, [][]interface{}{{1, "one"}, {2, "it's"}},
+			`INSERT INTO "t" ("id", "name") VALUES (1, 'one'), (2, 'it''s') ON CONFLICT ("id") ` +
+				`DO UPDATE SET "name" = excluded."name"`},
+		{"composite key", `[{"column_id":0,"name":"a","primary_key":2},{"column_id":1,"name":"b","primary_key":1},` +
+			`{"column_id":2,"name":"v","primary_key":0}]`, []string{"a", "b", "v"}, [][]interface{}{{1, 2, nil}},
+			`INSERT INTO "t" ("a", "b", "v") VALUES (1, 2, NULL) ON CONFLICT ("b", "a") DO UPDATE SET "v" = excluded."v"`},
+		{"only key columns", `[{"column_id":0,"name":"id","primary_key":1},{"column_id":1,"name":"name","primary_key":0}]`,
+			[]string{"id"}, [][]interface{}{{3}}, `INSERT INTO "t" ("id") VALUES (3) ON CONFLICT ("id") DO NOTHING`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("columns", 200, tt.columns)
+			s.Handle("execute", 200, `{"rows_changed":2}`)
+			n, err := s.Connection().Upsert("me", "live.sqlite", "t", tt.cols, tt.rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 {
+				t.Errorf("got %d rows changed, want 2", n)
+			}
+			if sql := executedSQL(t, s); len(sql) != 1 || sql[0] != tt.wantSQL {
+				t.Errorf("executed %q, want %q", sql, tt.wantSQL)
+			}
+		})
+	}
+}
+
+func TestUpsertErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns string
+		cols    []string
+		rows    [][]interface{}
+	}{
+		{"no primary key", `[{"column_id":0,"name":"a","primary_key":0}]`, []string{"a"}, [][]interface{}{{1}}},
+		{"no columns", `[{"column_id":0,"name":"id","primary_key":1}]`, nil, [][]interface{}{{1}}},
+		{"wrong number of values", `[{"column_id":0,"name":"id","primary_key":1},{"column_id":1,"name":"b"}]`,
+			[]string{"id", "b"}, [][]interface{}{{1, 2}, {3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("columns", 200, tt.columns)
+			s.Handle("execute", 200, `{"rows_changed":1}`)
+			if _, err := s.Connection().Upsert("me", "live.sqlite", "t", tt.cols, tt.rows); err == nil {
+				t.Error("no error returned")
+			}
+			if sql := executedSQL(t, s); len(sql) != 0 {
+				t.Errorf("executed %q", sql)
+			}
+		})
+	}
+}
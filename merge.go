@@ -0,0 +1,140 @@
+package dbhub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// Merge merges the source branch of a database into the target branch, returning the ID of the resulting commit.  If
+// the server rejects the merge due to conflicting changes, the error returned wraps ErrMergeConflict.
+func (c Connection) Merge(dbOwner, dbName, sourceBranch, targetBranch, message string) (commitID string, err error) {
+	return c.MergeContext(context.Background(), dbOwner, dbName, sourceBranch, targetBranch, message)
+}
+
+// MergeContext is like Merge, but uses the given context for the request
+func (c Connection) MergeContext(ctx context.Context, dbOwner, dbName, sourceBranch, targetBranch, message string) (commitID string, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("source_branch", sourceBranch)
+	data.Set("target_branch", targetBranch)
+	if message != "" {
+		data.Set("commitmsg", message)
+	}
+
+	// Perform the merge
+	var resp *http.Response
+	queryUrl := c.apiURL("merge")
+	resp, err = c.doRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict:
+		e := responseError(resp)
+		e.err = ErrMergeConflict
+		err = e
+		return
+	default:
+		err = responseError(resp)
+		return
+	}
+
+	// Extract the ID of the merge commit
+	var response struct {
+		CommitID string `json:"commit_id"`
+	}
+	_, err = decodeResponse(queryUrl, resp.Body, &response)
+	if err != nil {
+		return
+	}
+	commitID = response.CommitID
+	return
+}
+
+// MergePreview returns the changes merging the source branch of a database into the target branch would bring in,
+// being those made on the source branch since it split from the target branch.  The returned boolean is true when the
+// merge is a fast-forward, which is the case when the target branch has no commits of its own since then.  Otherwise
+// both branches have changed, and the server may find their changes conflict when merging.
+func (c Connection) MergePreview(dbOwner, dbName, sourceBranch, targetBranch string) (diffs com.Diffs, fastForward bool, err error) {
+	return c.MergePreviewContext(context.Background(), dbOwner, dbName, sourceBranch, targetBranch)
+}
+
+// MergePreviewContext is like MergePreview, but uses the given context for the requests
+func (c Connection) MergePreviewContext(ctx context.Context, dbOwner, dbName, sourceBranch, targetBranch string) (diffs com.Diffs, fastForward bool, err error) {
+	meta, err := c.MetadataContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	source, ok := meta.Branches[sourceBranch]
+	if !ok {
+		err = fmt.Errorf("unknown branch '%s'", sourceBranch)
+		return
+	}
+	target, ok := meta.Branches[targetBranch]
+	if !ok {
+		err = fmt.Errorf("unknown branch '%s'", targetBranch)
+		return
+	}
+	base := mergeBase(meta.Commits, source.Commit, target.Commit)
+	if base == "" {
+		err = fmt.Errorf("branches '%s' and '%s' have no common history", sourceBranch, targetBranch)
+		return
+	}
+
+	// Generate the changes made on the source branch since the merge base, so changes made only on the target branch
+	// aren't shown as being reverted
+	diffs, err = c.DiffContext(ctx, dbOwner, dbName, Identifier{CommitID: base}, "", "",
+		Identifier{CommitID: source.Commit}, PreservePkMerge)
+	if err != nil {
+		return
+	}
+	fastForward = base == target.Commit
+	return
+}
+
+// mergeBase returns the closest commit in the history of the commit "source" which is also part of the history of the
+// commit "target" (either of them can be the commit itself), or an empty string if they have no history in common
+func mergeBase(commits map[string]com.CommitEntry, source, target string) string {
+	inTarget := make(map[string]bool)
+	walkHistory(commits, target, func(id string) bool {
+		inTarget[id] = true
+		return true
+	})
+	var base string
+	walkHistory(commits, source, func(id string) bool {
+		if inTarget[id] {
+			base = id
+			return false
+		}
+		return true
+	})
+	return base
+}
+
+// walkHistory calls fn for the commit "id" and each of its ancestors, closest first, until fn returns false
+func walkHistory(commits map[string]com.CommitEntry, id string, fn func(id string) bool) {
+	seen := make(map[string]bool)
+	queue := []string{id}
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == "" || seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		if !fn(cur) {
+			return
+		}
+		commit, ok := commits[cur]
+		if !ok {
+			continue
+		}
+		queue = append(queue, commit.Parent)
+		queue = append(queue, commit.OtherParents...)
+	}
+}
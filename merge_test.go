@@ -0,0 +1,77 @@
+package dbhub_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+func TestMergePreview(t *testing.T) {
+	// The history is c1 <- c2 on one side, and c1 <- c3 <- c4 on the other, with c5 merging c2 into c4.  c6 is unrelated
+	commits := map[string]map[string]interface{}{
+		"c1": {"id": "c1"},
+		"c2": {"id": "c2", "parent": "c1"},
+		"c3": {"id": "c3", "parent": "c1"},
+		"c4": {"id": "c4", "parent": "c3"},
+		"c5": {"id": "c5", "parent": "c4", "other_parents": []string{"c2"}},
+		"c6": {"id": "c6"},
+	}
+	tests := []struct {
+		name            string
+		source, target  string
+		wantBase        string
+		wantFastForward bool
+		wantErr         bool
+	}{
+		{name: "diverged", source: "c4", target: "c2", wantBase: "c1"},
+		{name: "fast-forward", source: "c4", target: "c3", wantBase: "c3", wantFastForward: true},
+		{name: "target already merged", source: "c5", target: "c2", wantBase: "c2", wantFastForward: true},
+		{name: "source behind target", source: "c3", target: "c5", wantBase: "c3"},
+		{name: "no common history", source: "c4", target: "c6", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			meta, err := json.Marshal(map[string]interface{}{
+				"branches": map[string]interface{}{
+					"feature": map[string]string{"commit": tt.source},
+					"main":    map[string]string{"commit": tt.target},
+				},
+				"commits":        commits,
+				"default_branch": "main",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Handle("metadata", 200, string(meta))
+			s.Handle("diff", 200, `{"diff":[]}`)
+
+			_, fastForward, err := s.Connection().MergePreview("me", "db.sqlite", "feature", "main")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if fastForward != tt.wantFastForward {
+				t.Errorf("got fast-forward %v, want %v", fastForward, tt.wantFastForward)
+			}
+			var diffs int
+			for _, req := range s.Requests() {
+				if req.Endpoint != "diff" {
+					continue
+				}
+				diffs++
+				if a, b := req.Form.Get("commit_a"), req.Form.Get("commit_b"); a != tt.wantBase || b != tt.source {
+					t.Errorf("diffed %s to %s, want %s to %s", a, b, tt.wantBase, tt.source)
+				}
+			}
+			wantDiffs := 1
+			if tt.wantErr {
+				wantDiffs = 0
+			}
+			if diffs != wantDiffs {
+				t.Errorf("got %d diff requests, want %d", diffs, wantDiffs)
+			}
+		})
+	}
+}
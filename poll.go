@@ -0,0 +1,97 @@
+package dbhub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// QueryUntil runs a SQL query (SELECT only) repeatedly, pausing for interval between attempts, until it returns at
+// least one row.  This is useful for waiting on data which is being added asynchronously.  If the context ends first,
+// the context error is returned.  Other errors stop the polling straight away.  BLOB fields are handled as given by
+// the DefaultBlobBase64 setting of the connection.
+func (c Connection) QueryUntil(ctx context.Context, dbOwner, dbName, sql string, interval time.Duration) (out Results, err error) {
+	for {
+		out, err = c.QueryContext(ctx, dbOwner, dbName, Identifier{}, c.DefaultBlobBase64, sql)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return
+		}
+		if !out.Empty() {
+			return
+		}
+
+		// Wait before trying again
+		t := time.NewTimer(interval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// FollowBranch watches a branch of a database for new commits, checking its head commit every interval.  The ID of
+// the current head commit is sent on the returned channel first, followed by the ID of each new head commit as the
+// branch changes.  Errors while checking are ignored, with the next check trying again.  The channel is closed once
+// the context ends.  An error is returned straight away if the branch can't be looked up initially.
+func (c Connection) FollowBranch(ctx context.Context, dbOwner, dbName, branch string, interval time.Duration) (<-chan string, error) {
+	head, _, err := c.branchHead(ctx, dbOwner, dbName, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	heads := make(chan string, 1)
+	heads <- head
+	go func() {
+		defer close(heads)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+			h, _, err := c.branchHead(ctx, dbOwner, dbName, branch)
+			if err != nil || h == head {
+				continue
+			}
+			head = h
+			select {
+			case heads <- head:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return heads, nil
+}
+
+// branchHead returns the ID of the head commit of a branch, along with the name of the branch.  If no branch is given,
+// the default branch is used.
+func (c Connection) branchHead(ctx context.Context, dbOwner, dbName, branch string) (commitID, branchName string, err error) {
+	var branches map[string]com.BranchEntry
+	var defaultBranch string
+	branches, defaultBranch, err = c.BranchesContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	branchName = branch
+	if branchName == "" {
+		branchName = defaultBranch
+	}
+	b, ok := branches[branchName]
+	if !ok {
+		err = fmt.Errorf("branch '%s' not found", branchName)
+		return
+	}
+	commitID = b.Commit
+	return
+}
@@ -0,0 +1,461 @@
+package dbhub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// QueryHandle runs SQL queries (SELECT only) on a database, and allows them to be cancelled while they're in progress.
+// It's safe for concurrent use.
+type QueryHandle struct {
+	c       Connection
+	dbOwner string
+	dbName  string
+
+	mu      sync.Mutex
+	nextID  int
+	cancels map[int]context.CancelFunc
+}
+
+// NewQuery returns a handle for running queries on the given database, which can be cancelled from any goroutine
+func (c Connection) NewQuery(dbOwner, dbName string) *QueryHandle {
+	return &QueryHandle{c: c, dbOwner: dbOwner, dbName: dbName, cancels: make(map[int]context.CancelFunc)}
+}
+
+// Cancel aborts all queries currently being run by the handle.  They return context.Canceled as their error
+func (h *QueryHandle) Cancel() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, cancel := range h.cancels {
+		cancel()
+		delete(h.cancels, id)
+	}
+}
+
+// Run runs a SQL query (SELECT only) on the database of the handle.  It can be aborted by cancelling the context, or by
+// calling Cancel().  BLOB fields are handled as given by the DefaultBlobBase64 setting of the connection.
+func (h *QueryHandle) Run(ctx context.Context, sql string) (out Results, err error) {
+	// Keep track of the query, so Cancel() can abort it
+	ctx, cancel := context.WithCancel(ctx)
+	h.mu.Lock()
+	id := h.nextID
+	h.nextID++
+	h.cancels[id] = cancel
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.cancels, id)
+		h.mu.Unlock()
+		cancel()
+	}()
+
+	out, err = h.c.QueryContext(ctx, h.dbOwner, h.dbName, Identifier{}, h.c.DefaultBlobBase64, sql)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+// QueryPage runs a SQL query (SELECT only) on the chosen database, returning one page of the results.  Pages are
+// numbered from 1.  The total number of rows in the full result set is also returned, which is counted in parallel
+// with retrieving the page.  BLOB fields are handled as given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) QueryPage(dbOwner, dbName, sql string, page, pageSize int) (out PagedResults, err error) {
+	return c.QueryPageContext(context.Background(), dbOwner, dbName, sql, page, pageSize)
+}
+
+// QueryPageContext is like QueryPage, but uses the given context for the requests
+func (c Connection) QueryPageContext(ctx context.Context, dbOwner, dbName, sql string, page, pageSize int) (out PagedResults, err error) {
+	if page < 1 {
+		err = fmt.Errorf("invalid page number %d", page)
+		return
+	}
+	if pageSize < 1 {
+		err = fmt.Errorf("invalid page size %d", pageSize)
+		return
+	}
+	out.Page = page
+	out.PageSize = pageSize
+
+	// The query is used as a subquery, so it can't have a trailing semicolon
+	sql = trimStatement(sql)
+
+	// Run the row count and page queries at the same time
+	var wg sync.WaitGroup
+	var countErr, pageErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		out.TotalRows, countErr = c.queryCount(ctx, dbOwner, dbName, fmt.Sprintf("SELECT COUNT(*) FROM (%s)", sql))
+	}()
+	go func() {
+		defer wg.Done()
+		out.Results, pageErr = c.QueryDefaultContext(ctx, dbOwner, dbName, Identifier{},
+			fmt.Sprintf("SELECT * FROM (%s) LIMIT %d OFFSET %d", sql, pageSize, (page-1)*pageSize))
+	}()
+	wg.Wait()
+	if countErr != nil {
+		err = countErr
+		return
+	}
+	if pageErr != nil {
+		err = pageErr
+		return
+	}
+	out.HasNext = int64(page)*int64(pageSize) < out.TotalRows
+	return
+}
+
+// QueryPrepared runs a parameterised SQL query (SELECT only) on the chosen database.  When the server supports it, the
+// SQL and the parameter values are sent separately, with the server binding the values to the "?" placeholders, which
+// avoids any need for escaping values in the SQL.  Otherwise the values are bound with BindArgs() before the query is
+// sent.  Support is detected with a small query the first time QueryPrepared is used with a server.  JSON has no way
+// to send BLOBs, so queries with []byte values always have them bound with BindArgs().  BLOB fields are handled as
+// given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) QueryPrepared(dbOwner, dbName, sql string, args []interface{}) (out Results, err error) {
+	return c.QueryPreparedContext(context.Background(), dbOwner, dbName, sql, args)
+}
+
+// QueryPreparedContext is like QueryPrepared, but uses the given context for the requests
+func (c Connection) QueryPreparedContext(ctx context.Context, dbOwner, dbName, sql string, args []interface{}) (out Results, err error) {
+	if hasBlob(args) {
+		return c.QueryWithParamsContext(ctx, dbOwner, dbName, sql, args...)
+	}
+	supported, err := c.serverBindsParams(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	if !supported {
+		return c.QueryWithParamsContext(ctx, dbOwner, dbName, sql, args...)
+	}
+	data, err := c.preparedVals(dbOwner, dbName, sql, args)
+	if err != nil {
+		return
+	}
+
+	// Run the query on the remote database
+	out, err = c.query(ctx, data, c.DefaultBlobBase64)
+	return
+}
+
+// QueryStream runs a SQL query (SELECT only) on the chosen database, calling fn for each row of the results as it's
+// received.  Rows are decoded one at a time rather than the whole response being held in memory, so this is suited
+// to queries returning a very large number of rows.  If fn returns an error, no further rows are processed and the
+// error is returned.  The "blobBase64" boolean is used the same way as with Query().
+func (c Connection) QueryStream(dbOwner, dbName string, blobBase64 bool, sql string, fn func(ResultRow) error) (err error) {
+	return c.QueryStreamContext(context.Background(), dbOwner, dbName, blobBase64, sql, fn)
+}
+
+// QueryStreamContext is like QueryStream, but uses the given context for the request
+func (c Connection) QueryStreamContext(ctx context.Context, dbOwner, dbName string, blobBase64 bool, sql string, fn func(ResultRow) error) (err error) {
+	return c.queryStream(ctx, dbOwner, dbName, Identifier{}, blobBase64, sql, 0, fn)
+}
+
+// QueryStreamLimit is like QueryStream, but stops after fn has been called for maxRows rows.  The rest of the response
+// isn't downloaded, as the connection to the server is closed straight away.  This is useful for previewing the
+// results of queries which return a very large number of rows.  A maxRows of 0 or less means there's no limit.
+func (c Connection) QueryStreamLimit(dbOwner, dbName string, blobBase64 bool, sql string, maxRows int, fn func(ResultRow) error) (err error) {
+	return c.QueryStreamLimitContext(context.Background(), dbOwner, dbName, blobBase64, sql, maxRows, fn)
+}
+
+// QueryStreamLimitContext is like QueryStreamLimit, but uses the given context for the request
+func (c Connection) QueryStreamLimitContext(ctx context.Context, dbOwner, dbName string, blobBase64 bool, sql string, maxRows int, fn func(ResultRow) error) (err error) {
+	return c.queryStream(ctx, dbOwner, dbName, Identifier{}, blobBase64, sql, maxRows, fn)
+}
+
+// QueryTyped runs a SQL query (SELECT only) on the chosen database, returning the results with the type of each field
+// kept.  Unlike Query(), NULLs, empty strings, and zero values can all be told apart, and numbers are returned as
+// numbers.  BLOB fields are returned as their raw bytes.
+func (c Connection) QueryTyped(dbOwner, dbName string, ident Identifier, sql string) (out TypedResults, err error) {
+	return c.QueryTypedContext(context.Background(), dbOwner, dbName, ident, sql)
+}
+
+// QueryTypedContext is like QueryTyped, but uses the given context for the request
+func (c Connection) QueryTypedContext(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string) (out TypedResults, err error) {
+	returnedData, err := c.querySQLRaw(ctx, dbOwner, dbName, ident, sql)
+	if err != nil {
+		return
+	}
+	out = convertTypedRows(returnedData)
+	return
+}
+
+// QueryTypedWithOptions is like QueryTyped, but with options for decoding the results.  The fields of the result
+// columns named in opts.TimeColumns are decoded into time.Time values, in the location given by opts.Location.  An
+// error is returned if one of them can't be decoded.
+func (c Connection) QueryTypedWithOptions(dbOwner, dbName string, ident Identifier, sql string, opts QueryTypedOptions) (out TypedResults, err error) {
+	return c.QueryTypedWithOptionsContext(context.Background(), dbOwner, dbName, ident, sql, opts)
+}
+
+// QueryTypedWithOptionsContext is like QueryTypedWithOptions, but uses the given context for the request
+func (c Connection) QueryTypedWithOptionsContext(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string, opts QueryTypedOptions) (out TypedResults, err error) {
+	out, err = c.QueryTypedContext(ctx, dbOwner, dbName, ident, sql)
+	if err != nil {
+		return
+	}
+	err = decodeTimes(&out, opts)
+	return
+}
+
+// QueryWithParams runs a parameterised SQL query (SELECT only) on the chosen database.  The "?" and "?NNN" placeholders
+// in the SQL are given their values in order from args, and named placeholders (":name", "@name", or "$name") are
+// given theirs with Named().  Strings, numbers, NULLs (nil), and []byte BLOBs are quoted and escaped by BindArgs()
+// before the query is sent.  BLOB fields are handled as given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) QueryWithParams(dbOwner, dbName, sql string, args ...interface{}) (out Results, err error) {
+	return c.QueryWithParamsContext(context.Background(), dbOwner, dbName, sql, args...)
+}
+
+// QueryWithParamsContext is like QueryWithParams, but uses the given context for the request
+func (c Connection) QueryWithParamsContext(ctx context.Context, dbOwner, dbName, sql string, args ...interface{}) (out Results, err error) {
+	sql, err = bindArgs(sql, args)
+	if err != nil {
+		return
+	}
+	out, err = c.QueryDefaultContext(ctx, dbOwner, dbName, Identifier{}, sql)
+	return
+}
+
+// query sends a prepared set of query parameters to the DBHub.io query end point, returning the converted results
+func (c Connection) query(ctx context.Context, data url.Values, blobBase64 bool) (out Results, err error) {
+	returnedData, err := c.queryRaw(ctx, data)
+	if err != nil {
+		return
+	}
+	out = convertRows(returnedData, blobBase64)
+	return
+}
+
+// paramsSupport records whether the query end point of each server binds the values sent in the "params" field,
+// keyed by the URL of the end point
+var paramsSupport sync.Map
+
+// paramsProbe is the value used to check whether a server binds the values sent in the "params" field
+const paramsProbe = "go-dbhub params probe"
+
+// hasBlob returns true if any of the query parameter values is a BLOB, which would be sent as base64 encoded text if
+// marshalled as JSON
+func hasBlob(args []interface{}) bool {
+	for _, j := range args {
+		if n, ok := j.(NamedArg); ok {
+			j = n.Value
+		}
+		if _, ok := j.([]byte); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// preparedVals returns the API parameters for a query with its parameter values sent separately in the "params" field
+func (c Connection) preparedVals(dbOwner, dbName, sql string, args []interface{}) (data url.Values, err error) {
+	data = c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
+	if args == nil {
+		args = []interface{}{}
+	}
+	params, err := json.Marshal(args)
+	if err != nil {
+		return
+	}
+	data.Set("params", string(params))
+	return
+}
+
+// serverBindsParams returns true if the query end point binds the values sent in the "params" field.  Servers without
+// support ignore the field, leaving the placeholders unbound (so NULL), which is spotted by querying a single bound
+// value.  A server rejecting the field as a bad request is treated as not supporting it either.  The result of a
+// successful check is remembered for each server.
+func (c Connection) serverBindsParams(ctx context.Context, dbOwner, dbName string) (supported bool, err error) {
+	queryUrl := c.apiURL("query")
+	if v, ok := paramsSupport.Load(queryUrl); ok {
+		return v.(bool), nil
+	}
+	data, err := c.preparedVals(dbOwner, dbName, "SELECT ?", []interface{}{paramsProbe})
+	if err != nil {
+		return
+	}
+	res, err := c.query(ctx, data, false)
+	var e *APIError
+	if errors.As(err, &e) && e.Code == http.StatusBadRequest {
+		// The request could also have been refused for another reason (eg an unknown database), so this isn't
+		// remembered
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	supported = len(res.Rows) == 1 && len(res.Rows[0].Fields) == 1 && res.Rows[0].Fields[0] == paramsProbe
+	paramsSupport.Store(queryUrl, supported)
+	return
+}
+
+// queryCount runs a SQL query (SELECT only) returning a single integer, such as a row count
+func (c Connection) queryCount(ctx context.Context, dbOwner, dbName, sql string) (n int64, err error) {
+	res, err := c.QueryTypedContext(ctx, dbOwner, dbName, Identifier{}, sql)
+	if err != nil {
+		return
+	}
+	if len(res.Rows) != 1 || len(res.Rows[0]) != 1 {
+		err = fmt.Errorf("unexpected row count result")
+		return
+	}
+	n, ok := res.Rows[0][0].Value.(int64)
+	if !ok {
+		err = fmt.Errorf("unexpected row count value '%v'", res.Rows[0][0].Value)
+	}
+	return
+}
+
+// queryRaw sends a prepared set of query parameters to the DBHub.io query end point, returning the rows exactly as
+// provided by the server
+func (c Connection) queryRaw(ctx context.Context, data url.Values) (returnedData []com.DataRow, err error) {
+	queryUrl := c.apiURL("query")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &returnedData)
+	return
+}
+
+// querySQLRaw runs a SQL query (SELECT only) on the chosen database, returning the rows exactly as provided by the
+// server
+func (c Connection) querySQLRaw(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string) (returnedData []com.DataRow, err error) {
+	data := c.PrepareVals(dbOwner, dbName, ident)
+	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
+	return c.queryRaw(ctx, data)
+}
+
+// querySQLExact is like querySQLRaw, but the numbers in the rows are decoded as json.Number values holding the text
+// sent by the server rather than as float64, so integers too large for a float64 to hold exactly aren't rounded
+func (c Connection) querySQLExact(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string) (returnedData []com.DataRow, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, ident)
+	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
+
+	// Run the query on the remote database
+	queryUrl := c.apiURL("query")
+	body, err := c.sendRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	err = streamRows(dec, func(row com.DataRow) error {
+		returnedData = append(returnedData, row)
+		return nil
+	})
+	err = contextError(ctx, queryUrl, err)
+	return
+}
+
+// queryStream runs a SQL query (SELECT only), decoding the rows of the response one at a time and calling fn for each,
+// up to maxRows rows (if greater than 0)
+func (c Connection) queryStream(ctx context.Context, dbOwner, dbName string, ident Identifier, blobBase64 bool, sql string, maxRows int, fn func(ResultRow) error) (err error) {
+	return c.queryStreamRaw(ctx, dbOwner, dbName, ident, sql, maxRows, func(row com.DataRow) error {
+		return fn(convertRow(row, blobBase64))
+	})
+}
+
+// queryStreamRaw runs a SQL query (SELECT only), calling fn for each row of the response exactly as provided by the
+// server, as it's decoded.  If maxRows is greater than 0, the response is closed without reading the rest of it once
+// that many rows have been processed.
+func (c Connection) queryStreamRaw(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string, maxRows int, fn func(com.DataRow) error) (err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, ident)
+	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
+
+	// Run the query on the remote database
+	queryUrl := c.apiURL("query")
+	body, err := c.sendRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	var fnErr error
+	n := 0
+	err = streamRows(json.NewDecoder(body), func(row com.DataRow) error {
+		fnErr = fn(row)
+		if fnErr != nil {
+			return fnErr
+		}
+		n++
+		if maxRows > 0 && n >= maxRows {
+			return errRowLimit
+		}
+		return nil
+	})
+	if err == errRowLimit {
+		err = nil
+		return
+	}
+	if fnErr == nil {
+		err = contextError(ctx, queryUrl, err)
+	}
+	return
+}
+
+// streamRows decodes the rows of a query response one at a time, calling fn for each.  As with decodeResponse(), the
+// rows can be wrapped in an object holding them in a "data" field.  Errors from fn are returned unchanged, while
+// decoding errors are wrapped with the name of the end point.
+func streamRows(dec *json.Decoder, fn func(com.DataRow) error) (err error) {
+	var decodeErr error
+	defer func() {
+		if decodeErr != nil {
+			err = fmt.Errorf("decoding query response: %w", decodeErr)
+		}
+	}()
+
+	// Find the start of the row array, skipping any other fields of a wrapping object
+	tok, decodeErr := dec.Token()
+	if decodeErr != nil || tok == nil {
+		return
+	}
+	if tok == json.Delim('{') {
+		for {
+			tok, decodeErr = dec.Token()
+			if decodeErr != nil {
+				return
+			}
+			if tok == json.Delim('}') {
+				return
+			}
+			if tok == "data" {
+				tok, decodeErr = dec.Token()
+				if decodeErr != nil || tok == nil {
+					return
+				}
+				break
+			}
+			var skip json.RawMessage
+			decodeErr = dec.Decode(&skip)
+			if decodeErr != nil {
+				return
+			}
+		}
+	}
+	if tok != json.Delim('[') {
+		decodeErr = fmt.Errorf("unexpected token %v", tok)
+		return
+	}
+
+	// Process the rows
+	for dec.More() {
+		var row com.DataRow
+		decodeErr = dec.Decode(&row)
+		if decodeErr != nil {
+			return
+		}
+		err = fn(row)
+		if err != nil {
+			return
+		}
+	}
+	_, decodeErr = dec.Token()
+	return
+}
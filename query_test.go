@@ -0,0 +1,500 @@
+package dbhub_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+// streamingRows is the number of rows sent by the server from newStreamingServer(), which is far more than any test
+// reads
+const streamingRows = 1000000
+
+// newStreamingServer starts a server which streams a very large query response, one row at a time.  The returned
+// channel is closed once the server notices the client has dropped the connection.
+func newStreamingServer(t *testing.T) (s *httptest.Server, dropped chan struct{}) {
+	t.Helper()
+	dropped = make(chan struct{})
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "[")
+		for i := 0; i < streamingRows; i++ {
+			sep := ","
+			if i == 0 {
+				sep = ""
+			}
+			_, err := fmt.Fprintf(w, `%s[{"Name":"n","Type":4,"Value":%d}]`, sep, i)
+			if err == nil {
+				flusher.Flush()
+			}
+			if err != nil || r.Context().Err() != nil {
+				close(dropped)
+				return
+			}
+		}
+		fmt.Fprint(w, "]")
+	}))
+	return
+}
+
+// waitDropped fails the test if the server from newStreamingServer() doesn't notice the connection being dropped
+func waitDropped(t *testing.T, dropped chan struct{}) {
+	t.Helper()
+	select {
+	case <-dropped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server didn't see the connection being closed")
+	}
+}
+
+func TestQueryStreamLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxRows int
+	}{
+		{"one row", 1},
+		{"several rows", 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dropped := newStreamingServer(t)
+			defer s.Close()
+			var got []string
+			err := newConnection(t, s).QueryStreamLimit("me", "db.sqlite", false, "SELECT n FROM big", tt.maxRows,
+				func(row dbhub.ResultRow) error {
+					got = append(got, row.Fields[0])
+					return nil
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.maxRows {
+				t.Fatalf("got %d rows, want %d", len(got), tt.maxRows)
+			}
+			if got[tt.maxRows-1] != fmt.Sprint(tt.maxRows-1) {
+				t.Errorf("last row is %s, want %d", got[tt.maxRows-1], tt.maxRows-1)
+			}
+			waitDropped(t, dropped)
+		})
+	}
+}
+
+func TestQueryStreamChunked(t *testing.T) {
+	const rows = 50
+	var body strings.Builder
+	body.WriteString("[")
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `[{"Name":"n","Type":4,"Value":%d},{"Name":"s","Type":3,"Value":"row %d"}]`, i, i)
+	}
+	body.WriteString("]")
+
+	// The response is flushed in pieces of the chosen size, so it's sent with chunked encoding and no Content-Length,
+	// with the pieces splitting rows (and values) apart
+	for _, chunk := range []int{1, 7, 100} {
+		t.Run(fmt.Sprintf("%d byte chunks", chunk), func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				b := body.String()
+				for len(b) != 0 {
+					n := chunk
+					if n > len(b) {
+						n = len(b)
+					}
+					fmt.Fprint(w, b[:n])
+					w.(http.Flusher).Flush()
+					b = b[n:]
+				}
+			}))
+			defer s.Close()
+			c := newConnection(t, s)
+
+			var got []string
+			err := c.QueryStream("me", "db.sqlite", false, "SELECT n, s FROM t", func(row dbhub.ResultRow) error {
+				got = append(got, row.Fields[0])
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("QueryStream() got error %v", err)
+			}
+			if len(got) != rows || got[rows-1] != fmt.Sprint(rows-1) {
+				t.Errorf("QueryStream() got %d rows, ending with %v", len(got), got[len(got)-1])
+			}
+
+			r, err := c.QueryRows("me", "db.sqlite", dbhub.Identifier{}, "SELECT n, s FROM t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			n := 0
+			for r.Next() {
+				n++
+			}
+			if err = r.Err(); err != nil {
+				t.Errorf("Rows.Err() got %v at the end of the results", err)
+			}
+			if n != rows {
+				t.Errorf("Rows got %d rows, want %d", n, rows)
+			}
+		})
+	}
+}
+
+func TestQueryHandleCancel(t *testing.T) {
+	// The server never answers, only noticing when the client gives up.  The request body has to be read for that
+	started := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+	h := newConnection(t, s).NewQuery("me", "db.sqlite")
+
+	const queries = 2
+	errs := make(chan error, queries)
+	for i := 0; i < queries; i++ {
+		go func() {
+			_, err := h.Run(context.Background(), "SELECT * FROM slow")
+			errs <- err
+		}()
+		<-started
+	}
+	h.Cancel()
+	for i := 0; i < queries; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("got error %v, want context.Canceled", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("the query wasn't aborted")
+		}
+	}
+
+	// Queries started afterwards aren't affected
+	go func() { <-started }()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := h.Run(ctx, "SELECT * FROM slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("query after Cancel() got error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestQueryTyped(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("query", 200, `[[{"Name":"e","Type":3,"Value":""},{"Name":"n","Type":2,"Value":null},`+
+		`{"Name":"i","Type":4,"Value":0},{"Name":"f","Type":5,"Value":0},{"Name":"t","Type":3,"Value":"0"},`+
+		`{"Name":"b","Type":0,"Value":""}]]`)
+	res, err := s.Connection().QueryTyped("me", "db.sqlite", dbhub.Identifier{}, "SELECT e, n, i, f, t, b FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 || len(res.Rows[0]) != 6 {
+		t.Fatalf("got rows %#v", res.Rows)
+	}
+
+	// Empty strings, NULLs, and zeros are all returned differently
+	tests := []struct {
+		col      string
+		want     interface{}
+		wantNull bool
+	}{
+		{"e", "", false},
+		{"n", nil, true},
+		{"i", int64(0), false},
+		{"f", float64(0), false},
+		{"t", "0", false},
+		{"b", []byte{}, false},
+	}
+	for k, tt := range tests {
+		t.Run(tt.col, func(t *testing.T) {
+			if res.ColNames[k] != tt.col {
+				t.Fatalf("column %d is named %q, want %q", k, res.ColNames[k], tt.col)
+			}
+			f := res.Rows[0][k]
+			if f.IsNull != tt.wantNull {
+				t.Errorf("IsNull is %v, want %v", f.IsNull, tt.wantNull)
+			}
+			if !reflect.DeepEqual(f.Value, tt.want) {
+				t.Errorf("got %#v, want %#v", f.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTypedTimes(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data not available:", err)
+	}
+	tests := []struct {
+		name    string
+		field   string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "ISO-8601 text", field: `{"Name":"at","Type":3,"Value":"2020-01-02T03:04:05Z"}`,
+			want: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{name: "ISO-8601 text with offset", field: `{"Name":"at","Type":3,"Value":"2020-01-02 04:04:05.5+01:00"}`,
+			want: time.Date(2020, 1, 2, 3, 4, 5, 5e8, time.UTC)},
+		{name: "ISO-8601 text without zone", field: `{"Name":"at","Type":3,"Value":"2020-01-02 03:04:05"}`,
+			loc: berlin, want: time.Date(2020, 1, 2, 3, 4, 5, 0, berlin)},
+		{name: "ISO-8601 date", field: `{"Name":"at","Type":3,"Value":"2020-01-02"}`,
+			want: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "epoch integer", field: `{"Name":"at","Type":4,"Value":1577934245}`,
+			want: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{name: "epoch integer in location", field: `{"Name":"at","Type":4,"Value":1577934245}`, loc: berlin,
+			want: time.Date(2020, 1, 2, 4, 4, 5, 0, berlin)},
+		{name: "epoch float", field: `{"Name":"at","Type":5,"Value":1577934245.25}`,
+			want: time.Date(2020, 1, 2, 3, 4, 5, 25e7, time.UTC)},
+		{name: "not a time", field: `{"Name":"at","Type":3,"Value":"yesterday"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("query", 200, `[[{"Name":"id","Type":4,"Value":1},`+tt.field+`]]`)
+			res, err := s.Connection().QueryTypedWithOptions("me", "db.sqlite", dbhub.Identifier{}, "SELECT id, at FROM t",
+				dbhub.QueryTypedOptions{TimeColumns: []string{"at"}, Location: tt.loc})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("no error returned")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := res.Rows[0][1].Value.(time.Time)
+			if !ok {
+				t.Fatalf("got a %T, want a time.Time", res.Rows[0][1].Value)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			wantLoc := tt.loc
+			if wantLoc == nil {
+				wantLoc = time.UTC
+			}
+			if got.Location() != wantLoc {
+				t.Errorf("got location %v, want %v", got.Location(), wantLoc)
+			}
+			if id, ok := res.Rows[0][0].Value.(int64); !ok || id != 1 {
+				t.Errorf("other column holds %#v, want int64 1", res.Rows[0][0].Value)
+			}
+		})
+	}
+}
+
+func TestQueryTypedTimesNull(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("query", 200, `[[{"Name":"at","Type":2,"Value":null}]]`)
+	res, err := s.Connection().QueryTypedWithOptions("me", "db.sqlite", dbhub.Identifier{}, "SELECT at FROM t",
+		dbhub.QueryTypedOptions{TimeColumns: []string{"at"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := res.Rows[0][0]; !f.IsNull || f.Value != nil {
+		t.Errorf("NULL time decoded as %#v", f)
+	}
+}
+
+func TestQueryPrepared(t *testing.T) {
+	const sql = "SELECT * FROM t WHERE a = ? AND b = ?"
+	tests := []struct {
+		name       string
+		args       []interface{}
+		binds      bool // Whether the server binds the values in the params field
+		probeFails bool // Whether the server rejects the support check as a bad request
+		wantSQL    string
+		wantParams string
+		wantProbes int // Number of support checks made by two queries
+	}{
+		{name: "server binds params", binds: true, wantSQL: sql, wantParams: `[1,"x"]`, wantProbes: 1},
+		{name: "server ignores params", wantSQL: "SELECT * FROM t WHERE a = 1 AND b = 'x'", wantProbes: 1},
+		{name: "server rejects params", probeFails: true, wantSQL: "SELECT * FROM t WHERE a = 1 AND b = 'x'",
+			wantProbes: 2},
+		{name: "blob bound in the SQL", args: []interface{}{1, []byte{0xab, 0x01}}, binds: true,
+			wantSQL: "SELECT * FROM t WHERE a = 1 AND b = X'ab01'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var queries []url.Values
+			probes := 0
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				b, _ := base64.StdEncoding.DecodeString(r.PostForm.Get("sql"))
+				if string(b) != "SELECT ?" {
+					mu.Lock()
+					queries = append(queries, r.PostForm)
+					mu.Unlock()
+					fmt.Fprint(w, "[]")
+					return
+				}
+
+				// Answer the support check
+				mu.Lock()
+				probes++
+				mu.Unlock()
+				if tt.probeFails {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"error":"unknown field params"}`)
+					return
+				}
+				value := "null"
+				if tt.binds {
+					var params []interface{}
+					json.Unmarshal([]byte(r.PostForm.Get("params")), &params)
+					v, _ := json.Marshal(params[0])
+					value = string(v)
+				}
+				fmt.Fprintf(w, `[[{"Name":"?","Type":3,"Value":%s}]]`, value)
+			}))
+			defer s.Close()
+			c := newConnection(t, s)
+
+			args := tt.args
+			if args == nil {
+				args = []interface{}{1, "x"}
+			}
+			for i := 0; i < 2; i++ {
+				_, err := c.QueryPrepared("me", "db.sqlite", sql, args)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if probes != tt.wantProbes {
+				t.Errorf("support checked %d times, want %d", probes, tt.wantProbes)
+			}
+			if len(queries) != 2 {
+				t.Fatalf("got %d queries, want 2", len(queries))
+			}
+			for _, q := range queries {
+				b, _ := base64.StdEncoding.DecodeString(q.Get("sql"))
+				if string(b) != tt.wantSQL {
+					t.Errorf("got SQL %q, want %q", b, tt.wantSQL)
+				}
+				if _, sent := q["params"]; sent != (tt.wantParams != "") {
+					t.Errorf("params field sent: %v, want %v", sent, tt.wantParams != "")
+				}
+				if got := q.Get("params"); got != tt.wantParams {
+					t.Errorf("got params %q, want %q", got, tt.wantParams)
+				}
+			}
+		})
+	}
+}
+
+// newSQLServer starts a server which answers each query with the response body chosen by respond, given the SQL of
+// the query
+func newSQLServer(t *testing.T, respond func(sql string) string) (s *httptest.Server) {
+	t.Helper()
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		b, err := base64.StdEncoding.DecodeString(r.PostForm.Get("sql"))
+		if err != nil {
+			t.Errorf("decoding the SQL of a query: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, respond(string(b)))
+	}))
+	return
+}
+
+func TestQueryPage(t *testing.T) {
+	tests := []struct {
+		name        string
+		total       int64
+		page        int
+		wantHasNext bool
+	}{
+		{"first page", 25, 1, true},
+		{"last page", 25, 3, false},
+		{"exactly full", 20, 2, false},
+		{"million rows", 1000000, 1, true},
+		{"last page of a billion rows", 1234567890, 123456789, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSQLServer(t, func(sql string) string {
+				if strings.HasPrefix(sql, "SELECT COUNT(*) FROM (SELECT * FROM t)") {
+					// Large numbers are sent as they are by the server, but must not be read as floats
+					return fmt.Sprintf(`[[{"Name":"COUNT(*)","Type":4,"Value":%d}]]`, tt.total)
+				}
+				return `[[{"Name":"n","Type":4,"Value":1}]]`
+			})
+			defer s.Close()
+			res, err := newConnection(t, s).QueryPage("me", "db.sqlite", "SELECT * FROM t;", tt.page, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.TotalRows != tt.total {
+				t.Errorf("got %d total rows, want %d", res.TotalRows, tt.total)
+			}
+			if res.HasNext != tt.wantHasNext {
+				t.Errorf("got HasNext %v, want %v", res.HasNext, tt.wantHasNext)
+			}
+			if res.Page != tt.page || res.PageSize != 10 || res.Len() != 1 {
+				t.Errorf("got page %d of size %d with %d rows", res.Page, res.PageSize, res.Len())
+			}
+		})
+	}
+}
+
+func TestBatchQuery(t *testing.T) {
+	// Each query returns its own SQL, apart from those on the "broken" database
+	s := newSQLServer(t, func(sql string) string {
+		if sql == "SELECT 'broken'" {
+			return `{"error":"no such table"}`
+		}
+		return fmt.Sprintf(`[[{"Name":"q","Type":3,"Value":%q}]]`, sql)
+	})
+	defer s.Close()
+	var queries []dbhub.BatchQueryItem
+	for i := 0; i < 10; i++ {
+		queries = append(queries, dbhub.BatchQueryItem{DBOwner: "me", DBName: "db.sqlite", SQL: fmt.Sprintf("SELECT %d", i)})
+	}
+	queries[4].SQL = "SELECT 'broken'"
+
+	results, err := newConnection(t, s).BatchQuery(queries, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(queries) {
+		t.Fatalf("got %d results, want %d", len(results), len(queries))
+	}
+	for i, r := range results {
+		if i == 4 {
+			if r.Err == nil {
+				t.Errorf("query %d: no error returned", i)
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Errorf("query %d: %v", i, r.Err)
+		} else if len(r.Results.Rows) != 1 || r.Results.Rows[0].Fields[0] != queries[i].SQL {
+			t.Errorf("query %d: got results %+v, want those of %s", i, r.Results, queries[i].SQL)
+		}
+	}
+}
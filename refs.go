@@ -0,0 +1,153 @@
+package dbhub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// refTimeFormats are the timestamp formats accepted by ResolveRef()
+var refTimeFormats = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"}
+
+// DiffRefs returns the changes to the tables, views, and other objects of a database between two of its versions,
+// given by anything accepted by ResolveRef() (eg a branch name and a commit ID).  Each object has its schema change
+// (if any) marked as added, deleted, or modified, along with its changed rows.  If refA is empty, refB is compared
+// with its parent commit.  For the initial commit, which doesn't have a parent, every table and view is reported as
+// added, without row details.
+func (c Connection) DiffRefs(dbOwner, dbName, refA, refB string) (changes []com.DiffObjectChangeset, err error) {
+	return c.DiffRefsContext(context.Background(), dbOwner, dbName, refA, refB)
+}
+
+// DiffRefsContext is like DiffRefs, but uses the given context for the requests
+func (c Connection) DiffRefsContext(ctx context.Context, dbOwner, dbName, refA, refB string) (changes []com.DiffObjectChangeset, err error) {
+	commitB, err := c.ResolveRefContext(ctx, dbOwner, dbName, refB)
+	if err != nil {
+		return
+	}
+	commitA := ""
+	if refA != "" {
+		commitA, err = c.ResolveRefContext(ctx, dbOwner, dbName, refA)
+		if err != nil {
+			return
+		}
+	} else {
+		var commits map[string]com.CommitEntry
+		commits, err = c.CommitsContext(ctx, dbOwner, dbName)
+		if err != nil {
+			return
+		}
+		commitA = commits[commitB].Parent
+		if commitA == "" {
+			return c.initialChanges(ctx, dbOwner, dbName, commitB)
+		}
+	}
+
+	// Generate the changes between the two commits
+	diffs, err := c.DiffContext(ctx, dbOwner, dbName, Identifier{CommitID: commitA}, "", "", Identifier{CommitID: commitB}, NoMerge)
+	if err != nil {
+		return
+	}
+	changes = diffs.Diff
+	return
+}
+
+// QueryAsOf runs a SQL query (SELECT only) on the version of a database given by ref, which can be anything accepted
+// by ResolveRef().  BLOB fields are handled as given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) QueryAsOf(dbOwner, dbName, ref, sql string) (out Results, err error) {
+	return c.QueryAsOfContext(context.Background(), dbOwner, dbName, ref, sql)
+}
+
+// QueryAsOfContext is like QueryAsOf, but uses the given context for the requests
+func (c Connection) QueryAsOfContext(ctx context.Context, dbOwner, dbName, ref, sql string) (out Results, err error) {
+	commitID, err := c.ResolveRefContext(ctx, dbOwner, dbName, ref)
+	if err != nil {
+		return
+	}
+	return c.QueryDefaultContext(ctx, dbOwner, dbName, Identifier{CommitID: commitID}, sql)
+}
+
+// ResolveRef returns the ID of the commit a reference points to.  The reference can be a commit ID, or the name of a
+// branch, tag, or release.  It can also be a timestamp (RFC 3339, "YYYY-MM-DD HH:MM:SS", or "YYYY-MM-DD"), which
+// resolves to the latest commit on the default branch made at or before that time.
+func (c Connection) ResolveRef(dbOwner, dbName, ref string) (commitID string, err error) {
+	return c.ResolveRefContext(context.Background(), dbOwner, dbName, ref)
+}
+
+// ResolveRefContext is like ResolveRef, but uses the given context for the request
+func (c Connection) ResolveRefContext(ctx context.Context, dbOwner, dbName, ref string) (commitID string, err error) {
+	meta, err := c.MetadataContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+
+	// Check the named references first
+	if _, ok := meta.Commits[ref]; ok {
+		return ref, nil
+	}
+	if b, ok := meta.Branches[ref]; ok {
+		return b.Commit, nil
+	}
+	if t, ok := meta.Tags[ref]; ok {
+		return t.Commit, nil
+	}
+	if r, ok := meta.Releases[ref]; ok {
+		return r.Commit, nil
+	}
+
+	// Try it as a timestamp
+	var ts time.Time
+	for _, f := range refTimeFormats {
+		ts, err = time.Parse(f, ref)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("unknown reference '%s'", ref)
+		return
+	}
+
+	// Walk back through the history of the default branch, until reaching a commit made at or before the timestamp
+	head, ok := meta.Branches[meta.DefBranch]
+	if !ok {
+		err = fmt.Errorf("default branch '%s' not found", meta.DefBranch)
+		return
+	}
+	for id := head.Commit; id != ""; {
+		commit, ok := meta.Commits[id]
+		if !ok {
+			err = fmt.Errorf("commit '%s' not found", id)
+			return
+		}
+		if !commit.Timestamp.After(ts) {
+			return id, nil
+		}
+		id = commit.Parent
+	}
+	err = fmt.Errorf("no commit at or before %s", ts.Format(time.RFC3339))
+	return
+}
+
+// initialChanges returns the objects of a database at its initial commit, as additions
+func (c Connection) initialChanges(ctx context.Context, dbOwner, dbName, commitID string) (changes []com.DiffObjectChangeset, err error) {
+	ident := Identifier{CommitID: commitID}
+	tables, err := c.TablesContext(ctx, dbOwner, dbName, ident)
+	if err != nil {
+		return
+	}
+	views, err := c.ViewsContext(ctx, dbOwner, dbName, ident)
+	if err != nil {
+		return
+	}
+	for _, j := range tables {
+		changes = append(changes, com.DiffObjectChangeset{ObjectName: j, ObjectType: "table",
+			Schema: &com.SchemaDiff{ActionType: com.ActionAdd}})
+	}
+	for _, j := range views {
+		changes = append(changes, com.DiffObjectChangeset{ObjectName: j, ObjectType: "view",
+			Schema: &com.SchemaDiff{ActionType: com.ActionAdd}})
+	}
+	return
+}
@@ -0,0 +1,328 @@
+package dbhub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// defaultCellWidth is the width values are truncated to by RenderTable()
+const defaultCellWidth = 40
+
+// timeZoneFormats are the ISO-8601 formats with a time zone accepted for the time columns of typed results
+var timeZoneFormats = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00"}
+
+// localTimeFormats are the ISO-8601 formats without a time zone accepted for the time columns of typed results.  They're
+// taken to be in the location given in the options.
+var localTimeFormats = []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "2006-01-02 15:04",
+	"2006-01-02T15:04", "2006-01-02"}
+
+// Columns2D returns the results in column order rather than row order, with one slice of values per column.  This is
+// handy for charting libraries.  The column names are needed, so an error is returned if they're not known.
+func (r Results) Columns2D() (names []string, cols [][]string, err error) {
+	if len(r.ColNames) == 0 {
+		if len(r.Rows) != 0 {
+			err = fmt.Errorf("results have no column names")
+		}
+		return
+	}
+	names = r.ColNames
+	cols = make([][]string, len(names))
+	for i := range cols {
+		cols[i] = make([]string, 0, len(r.Rows))
+	}
+	for i, row := range r.Rows {
+		if len(row.Fields) != len(names) {
+			err = fmt.Errorf("row %d has %d fields, but there are %d columns", i, len(row.Fields), len(names))
+			return nil, nil, err
+		}
+		for j, f := range row.Fields {
+			cols[j] = append(cols[j], f)
+		}
+	}
+	return
+}
+
+// Empty returns true if the results don't contain any rows
+func (r Results) Empty() bool {
+	return len(r.Rows) == 0
+}
+
+// Execute renders the results with a text/template or html/template template, writing the output to w.  The template
+// is given the rows as a list of maps from column name to value (see Maps()), so it can range over them and refer to
+// fields by name (eg {{range .}}{{.name}}{{end}}).
+func (r Results) Execute(tmpl Template, w io.Writer) (err error) {
+	maps, err := r.Maps()
+	if err != nil {
+		return
+	}
+	return tmpl.Execute(w, maps)
+}
+
+// Len returns the number of rows in the results
+func (r Results) Len() int {
+	return len(r.Rows)
+}
+
+// Maps returns the rows of the results as maps from column name to value.  The column names are needed, so an error is
+// returned if they're not known.
+func (r Results) Maps() (maps []map[string]string, err error) {
+	if len(r.ColNames) == 0 && len(r.Rows) != 0 {
+		err = fmt.Errorf("results have no column names")
+		return
+	}
+	maps = make([]map[string]string, 0, len(r.Rows))
+	for i, row := range r.Rows {
+		if len(row.Fields) != len(r.ColNames) {
+			err = fmt.Errorf("row %d has %d fields, but there are %d columns", i, len(row.Fields), len(r.ColNames))
+			return nil, err
+		}
+		m := make(map[string]string, len(r.ColNames))
+		for j, f := range row.Fields {
+			m[r.ColNames[j]] = f
+		}
+		maps = append(maps, m)
+	}
+	return
+}
+
+// RenderTable writes the results to w as a text table with borders, for display in a terminal.  The column names are
+// used as the header row when known.  Values wider than 40 characters are truncated, with an ellipsis marking where.
+func (r Results) RenderTable(w io.Writer) error {
+	return r.RenderTableWidth(w, defaultCellWidth)
+}
+
+// RenderTableWidth is like RenderTable, but truncates values wider than maxWidth characters instead.  A maxWidth of 0
+// or less means values are never truncated.
+func (r Results) RenderTableWidth(w io.Writer, maxWidth int) (err error) {
+	// Truncate the values, and work out the width of each column
+	var rows [][]string
+	if len(r.ColNames) != 0 {
+		rows = append(rows, r.ColNames)
+	}
+	for _, j := range r.Rows {
+		rows = append(rows, j.Fields)
+	}
+	var widths []int
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		for j, f := range row {
+			if maxWidth > 0 && utf8.RuneCountInString(f) > maxWidth {
+				f = string([]rune(f)[:maxWidth-1]) + "…"
+			}
+			cells[i] = append(cells[i], f)
+			if j == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(f); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	// Draw the table
+	var b strings.Builder
+	border := "+"
+	for _, n := range widths {
+		border += strings.Repeat("-", n+2) + "+"
+	}
+	border += "\n"
+	b.WriteString(border)
+	for i, row := range cells {
+		b.WriteString("|")
+		for j, n := range widths {
+			f := ""
+			if j < len(row) {
+				f = row[j]
+			}
+			b.WriteString(" " + f + strings.Repeat(" ", n-utf8.RuneCountInString(f)) + " |")
+		}
+		b.WriteString("\n")
+		if i == 0 && len(r.ColNames) != 0 {
+			b.WriteString(border)
+		}
+	}
+	if len(r.Rows) != 0 {
+		b.WriteString(border)
+	}
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+// convertRows converts the rows returned by the DBHub.io query end point into the more concise Results format.  The
+// "blobBase64" boolean specifies whether BLOB data fields should be base64 encoded, or just skipped using an empty
+// string as a placeholder.
+func convertRows(returnedData []com.DataRow, blobBase64 bool) (out Results) {
+	// The column names are included with each returned field
+	if len(returnedData) != 0 {
+		for _, l := range returnedData[0] {
+			out.ColNames = append(out.ColNames, l.Name)
+		}
+	}
+
+	// Loop through the results, converting it to a more concise output format
+	for _, j := range returnedData {
+		out.Rows = append(out.Rows, convertRow(j, blobBase64))
+	}
+	return
+}
+
+// convertRow converts a single row returned by the DBHub.io query end point into a ResultRow
+func convertRow(j com.DataRow, blobBase64 bool) (oneRow ResultRow) {
+	for _, l := range j {
+		switch l.Type {
+		case com.Integer:
+			// Integers are converted from their native value, as large ones would otherwise be given in exponent form
+			// (eg "1e+06") from the float64 they're decoded as
+			oneRow.Fields = append(oneRow.Fields, fmt.Sprint(nativeValue(l)))
+		case com.Float:
+			// Floats are formatted the same way whether they were decoded as a float64 or a json.Number
+			oneRow.Fields = append(oneRow.Fields, fmt.Sprint(nativeValue(l)))
+		case com.Text:
+			// Text fields are added to the output
+			oneRow.Fields = append(oneRow.Fields, fmt.Sprint(l.Value))
+		case com.Binary:
+			// BLOB data is optionally Base64 encoded, or just skipped (using an empty string as placeholder)
+			if blobBase64 {
+				// Safety check. Make sure we've received a string
+				if s, ok := l.Value.(string); ok {
+					oneRow.Fields = append(oneRow.Fields, base64.StdEncoding.EncodeToString([]byte(s)))
+				} else {
+					oneRow.Fields = append(oneRow.Fields, fmt.Sprintf("unexpected data type '%T' for returned BLOB", l.Value))
+				}
+			} else {
+				oneRow.Fields = append(oneRow.Fields, "")
+			}
+		default:
+			// All other value types are just output as an empty string (for now)
+			oneRow.Fields = append(oneRow.Fields, "")
+		}
+	}
+	return
+}
+
+// convertTypedRows converts the rows returned by the DBHub.io query end point into the TypedResults format
+func convertTypedRows(returnedData []com.DataRow) (out TypedResults) {
+	if len(returnedData) > 0 {
+		for _, l := range returnedData[0] {
+			out.ColNames = append(out.ColNames, l.Name)
+		}
+	}
+	out.Rows = make([][]TypedField, 0, len(returnedData))
+	for _, j := range returnedData {
+		row := make([]TypedField, 0, len(j))
+		for _, l := range j {
+			row = append(row, TypedField{Type: l.Type, Value: nativeValue(l), IsNull: l.Type == com.Null})
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	return
+}
+
+// decodeTimes decodes the fields of the time columns given in the options into time.Time values
+func decodeTimes(out *TypedResults, opts QueryTypedOptions) (err error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	isTime := make(map[string]bool, len(opts.TimeColumns))
+	for _, j := range opts.TimeColumns {
+		isTime[j] = true
+	}
+	for i, row := range out.Rows {
+		for k := range row {
+			if k >= len(out.ColNames) || !isTime[out.ColNames[k]] || row[k].IsNull {
+				continue
+			}
+			var t time.Time
+			t, err = decodeTime(row[k].Value, loc)
+			if err != nil {
+				err = fmt.Errorf("row %d, column '%s': %w", i, out.ColNames[k], err)
+				return
+			}
+			row[k].Value = t
+		}
+	}
+	return
+}
+
+// decodeTime decodes a timestamp stored as ISO-8601 text or as seconds since the Unix epoch, giving it in the location
+func decodeTime(v interface{}, loc *time.Location) (t time.Time, err error) {
+	switch val := v.(type) {
+	case int64:
+		return time.Unix(val, 0).In(loc), nil
+	case float64:
+		sec, frac := math.Modf(val)
+		return time.Unix(int64(sec), int64(frac*1e9)).In(loc), nil
+	case string:
+		s := strings.TrimSpace(val)
+		for _, f := range timeZoneFormats {
+			t, err = time.Parse(f, s)
+			if err == nil {
+				return t.In(loc), nil
+			}
+		}
+		for _, f := range localTimeFormats {
+			t, err = time.ParseInLocation(f, s, loc)
+			if err == nil {
+				return
+			}
+		}
+	}
+	err = fmt.Errorf("can't decode %v as a time", v)
+	return
+}
+
+// nativeValue returns a field returned by the DBHub.io query end point as a native Go value.  Integers are returned as
+// int64, floating point numbers as float64, text as string, BLOBs as []byte, and NULLs as nil
+func nativeValue(l com.DataValue) interface{} {
+	switch l.Type {
+	case com.Null:
+		return nil
+	case com.Integer:
+		switch v := l.Value.(type) {
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v)
+			}
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+		case string:
+			if i, err := json.Number(v).Int64(); err == nil {
+				return i
+			}
+		}
+	case com.Float:
+		switch v := l.Value.(type) {
+		case float64:
+			return v
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				return f
+			}
+		case string:
+			if f, err := json.Number(v).Float64(); err == nil {
+				return f
+			}
+		}
+	case com.Text:
+		if s, ok := l.Value.(string); ok {
+			return s
+		}
+		return fmt.Sprint(l.Value)
+	case com.Binary, com.Image:
+		if s, ok := l.Value.(string); ok {
+			return []byte(s)
+		}
+	}
+	return l.Value
+}
@@ -0,0 +1,31 @@
+package dbhub_test
+
+import (
+	"testing"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+)
+
+func TestResultsLen(t *testing.T) {
+	tests := []struct {
+		name      string
+		res       dbhub.Results
+		wantLen   int
+		wantEmpty bool
+	}{
+		{"nil rows", dbhub.Results{}, 0, true},
+		{"no rows", dbhub.Results{ColNames: []string{"a"}, Rows: []dbhub.ResultRow{}}, 0, true},
+		{"two rows", dbhub.Results{ColNames: []string{"a"}, Rows: []dbhub.ResultRow{{Fields: []string{"1"}},
+			{Fields: []string{"2"}}}}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := tt.res.Len(); n != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", n, tt.wantLen)
+			}
+			if e := tt.res.Empty(); e != tt.wantEmpty {
+				t.Errorf("Empty() = %v, want %v", e, tt.wantEmpty)
+			}
+		})
+	}
+}
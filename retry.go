@@ -0,0 +1,124 @@
+package dbhub
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// idempotencyKeyType is the type of the context key used for holding idempotency keys
+type idempotencyKeyType struct{}
+
+// idempotent holds the API end points which only read data, so requests to them are safe to repeat.  Requests to any
+// other end point may change data on the server (including end points added later and not listed here), so they're
+// never retried without an idempotency key.
+var idempotent = map[string]bool{
+	"blob":        true,
+	"branches":    true,
+	"columns":     true,
+	"commits":     true,
+	"databases":   true,
+	"diff":        true,
+	"download":    true,
+	"exportcsv":   true,
+	"indexes":     true,
+	"labels":      true,
+	"licence":     true,
+	"lockstatus":  true,
+	"metadata":    true,
+	"permissions": true,
+	"query":       true,
+	"releases":    true,
+	"tables":      true,
+	"tags":        true,
+	"views":       true,
+	"webhooks":    true,
+	"webpage":     true,
+}
+
+// WithIdempotencyKey returns a copy of the context holding an idempotency key.  Requests sent using the context
+// include the key, which lets the server detect repeated requests.  This allows them to be retried when a retry
+// policy is set, even for operations which change data (eg ExecuteContext()).
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyType{}, key)
+}
+
+// idempotencyKey returns the idempotency key held in the context, if any
+func idempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyType{}).(string)
+	return key
+}
+
+// isIdempotent returns true if a request to the given API end point is safe to repeat
+func isIdempotent(queryUrl string) bool {
+	return idempotent[endpointName(queryUrl)]
+}
+
+// maxRetries returns the number of times a failed request to the given API end point can be retried.  Requests which
+// may change data on the server are only retried when an idempotency key has been given, as the server could have
+// processed the request before the failure occurred.
+func (c Connection) maxRetries(ctx context.Context, queryUrl string) int {
+	if idempotencyKey(ctx) == "" && !isIdempotent(queryUrl) {
+		return 0
+	}
+	return c.Retry.MaxRetries
+}
+
+// shouldRetry returns true if a request failed in a way which may succeed when tried again
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTooEarly ||
+		resp.StatusCode >= 500
+}
+
+// wait returns how long to pause before the given retry attempt (counting from 0).  A Retry-After header in the failed
+// response takes priority over the delay worked out from the policy.
+func (p RetryPolicy) wait(attempt int, resp *http.Response) (d time.Duration) {
+	if after, ok := retryAfter(resp); ok {
+		d = after
+	} else {
+		d = p.Delay
+		if p.Backoff > 1 {
+			f := float64(p.Delay) * math.Pow(p.Backoff, float64(attempt))
+			d = time.Duration(math.MaxInt64)
+			if f < float64(math.MaxInt64) {
+				d = time.Duration(f)
+			}
+		}
+		if p.Jitter && d > 0 {
+			d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return
+}
+
+// retryAfter returns the pause asked for by the Retry-After header of a response, which can either be a number of
+// seconds or a date
+func retryAfter(resp *http.Response) (d time.Duration, ok bool) {
+	if resp == nil {
+		return
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		d = time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return
+}
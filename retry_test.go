@@ -0,0 +1,124 @@
+package dbhub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryWait(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{"constant", RetryPolicy{Delay: time.Second}, 3, "", time.Second},
+		{"backoff", RetryPolicy{Delay: time.Second, Backoff: 2}, 3, "", 8 * time.Second},
+		{"backoff limited", RetryPolicy{Delay: time.Second, Backoff: 2, MaxDelay: 5 * time.Second}, 3, "", 5 * time.Second},
+		{"backoff overflow", RetryPolicy{Delay: time.Second, Backoff: 10, MaxDelay: time.Minute}, 100, "", time.Minute},
+		{"retry after seconds", RetryPolicy{Delay: time.Second}, 0, "7", 7 * time.Second},
+		{"retry after limited", RetryPolicy{Delay: time.Second, MaxDelay: 3 * time.Second}, 0, "7", 3 * time.Second},
+		{"retry after in the past", RetryPolicy{Delay: time.Second}, 0, "Mon, 02 Jan 2006 15:04:05 GMT", 0},
+		{"retry after invalid", RetryPolicy{Delay: time.Second}, 0, "soon", time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			if got := tt.policy.wait(tt.attempt, resp); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryWaitJitter(t *testing.T) {
+	p := RetryPolicy{Delay: time.Second, Jitter: true}
+	for i := 0; i < 100; i++ {
+		if got := p.wait(0, nil); got < p.Delay/2 || got > p.Delay {
+			t.Fatalf("got %v, want between %v and %v", got, p.Delay/2, p.Delay)
+		}
+	}
+}
+
+func TestRetryRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		ctx      context.Context
+		status   int // The status of the failed responses, before the server succeeds
+		failures int32
+		wantReqs int32
+		wantErr  bool
+	}{
+		{name: "retried until success", endpoint: "tables", status: 503, failures: 2, wantReqs: 3},
+		{name: "too many failures", endpoint: "tables", status: 503, failures: 5, wantReqs: 4, wantErr: true},
+		{name: "rate limited", endpoint: "tables", status: 429, failures: 1, wantReqs: 2},
+		{name: "client error", endpoint: "tables", status: 400, failures: 1, wantReqs: 1, wantErr: true},
+		{name: "not idempotent", endpoint: "execute", status: 503, failures: 1, wantReqs: 1, wantErr: true},
+		{name: "unlisted write", endpoint: "setlabel", status: 503, failures: 1, wantReqs: 1, wantErr: true},
+		{name: "idempotency key", endpoint: "execute", ctx: WithIdempotencyKey(context.Background(), "k1"),
+			status: 503, failures: 1, wantReqs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqs int32
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&reqs, 1) <= tt.failures {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer s.Close()
+			c, err := New("test-api-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.ChangeServer(s.URL)
+			c.Retry = RetryPolicy{MaxRetries: 3, Delay: time.Hour}
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			// The server asks for no pause between attempts, so the hour long delay of the policy is never used
+			var out interface{}
+			err = c.sendRequestJSON(ctx, c.apiURL(tt.endpoint), nil, &out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if n := atomic.LoadInt32(&reqs); n != tt.wantReqs {
+				t.Errorf("server got %d requests, want %d", n, tt.wantReqs)
+			}
+		})
+	}
+}
+
+func TestRetryOnlyReads(t *testing.T) {
+	writes := []string{"createwebhook", "delete", "deletewebhook", "execute", "fork", "lock", "merge", "rename",
+		"setlabel", "setlicence", "star", "unlock", "unstar", "unwatch", "upload", "watch", "someday"}
+	c := Connection{Retry: RetryPolicy{MaxRetries: 3}}
+	keyed := WithIdempotencyKey(context.Background(), "k1")
+	for _, j := range writes {
+		if n := c.maxRetries(context.Background(), c.apiURL(j)); n != 0 {
+			t.Errorf("%s requests retried %d times without an idempotency key", j, n)
+		}
+		if n := c.maxRetries(keyed, c.apiURL(j)); n != 3 {
+			t.Errorf("%s requests with an idempotency key retried %d times, want 3", j, n)
+		}
+	}
+	for j := range idempotent {
+		if n := c.maxRetries(context.Background(), c.apiURL(j)); n != 3 {
+			t.Errorf("%s requests retried %d times, want 3", j, n)
+		}
+	}
+}
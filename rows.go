@@ -0,0 +1,464 @@
+package dbhub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// errRowLimit stops the decoding of a streamed query response once the maximum number of rows has been reached
+var errRowLimit = errors.New("row limit reached")
+
+// errRowsClosed stops the decoding of a streamed query response when its Rows are closed early
+var errRowsClosed = errors.New("rows are closed")
+
+// Rows is a database/sql style iterator over the results of QueryRows().  The rows are decoded from the response as
+// they're iterated over, rather than the whole response being held in memory first.  Always call Close() when done
+// with it, so the response is released.
+type Rows struct {
+	blobBase64 bool
+	body       io.Closer
+	closed     bool
+	cols       []string
+	cur        com.DataRow
+	done       chan struct{}
+	err        error
+	errc       chan error
+	rows       chan com.DataRow
+}
+
+// QueryRows runs a SQL query (SELECT only) on the chosen database, returning an iterator over the rows of the results.
+// As with QueryStream(), this is suited to queries returning a very large number of rows.  BLOB fields are handled as
+// given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) QueryRows(dbOwner, dbName string, ident Identifier, sql string) (rows *Rows, err error) {
+	return c.QueryRowsContext(context.Background(), dbOwner, dbName, ident, sql)
+}
+
+// QueryRowsContext is like QueryRows, but uses the given context for the request
+func (c Connection) QueryRowsContext(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string) (rows *Rows, err error) {
+	return c.QueryRowsLimitContext(ctx, dbOwner, dbName, ident, sql, 0)
+}
+
+// QueryRowsLimit is like QueryRows, but the iteration stops after maxRows rows.  The rest of the response isn't
+// downloaded, as the connection to the server is closed as soon as the last row has been decoded.  A maxRows of 0 or
+// less means there's no limit.
+func (c Connection) QueryRowsLimit(dbOwner, dbName string, ident Identifier, sql string, maxRows int) (rows *Rows, err error) {
+	return c.QueryRowsLimitContext(context.Background(), dbOwner, dbName, ident, sql, maxRows)
+}
+
+// QueryRowsLimitContext is like QueryRowsLimit, but uses the given context for the request
+func (c Connection) QueryRowsLimitContext(ctx context.Context, dbOwner, dbName string, ident Identifier, sql string, maxRows int) (rows *Rows, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, ident)
+	data.Set("sql", base64.StdEncoding.EncodeToString([]byte(sql)))
+
+	// Run the query on the remote database
+	queryUrl := c.apiURL("query")
+	body, err := c.sendRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+
+	// Decode the rows in the background, handing them over one at a time as they're asked for
+	rows = &Rows{
+		blobBase64: c.DefaultBlobBase64,
+		body:       body,
+		done:       make(chan struct{}),
+		errc:       make(chan error, 1),
+		rows:       make(chan com.DataRow),
+	}
+	go func() {
+		n := 0
+		e := streamRows(json.NewDecoder(body), func(row com.DataRow) error {
+			select {
+			case rows.rows <- row:
+			case <-rows.done:
+				return errRowsClosed
+			}
+			n++
+			if maxRows > 0 && n >= maxRows {
+				return errRowLimit
+			}
+			return nil
+		})
+		switch e {
+		case errRowLimit:
+			// Don't wait for Close() to drop the connection, as the rest of the response would keep arriving
+			body.Close()
+		case errRowsClosed:
+		default:
+			rows.errc <- contextError(ctx, queryUrl, e)
+		}
+		close(rows.rows)
+	}()
+	return
+}
+
+// Close stops the iteration and releases the response.  It's safe to call more than once
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	err := r.body.Close()
+	for range r.rows {
+		// Wait for the decoding to finish
+	}
+	return err
+}
+
+// Columns returns the names of the result columns.  They're only known once the first row has been retrieved.
+func (r *Rows) Columns() ([]string, error) {
+	if r.closed && r.cols == nil {
+		return nil, fmt.Errorf("rows are closed")
+	}
+	return r.cols, nil
+}
+
+// Err returns the error (if any) encountered while retrieving the rows
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Next moves to the next row, returning false when there are no more rows or an error occurred.  Use Err() to tell
+// which happened.
+func (r *Rows) Next() bool {
+	if r.closed {
+		return false
+	}
+	row, ok := <-r.rows
+	if !ok {
+		select {
+		case r.err = <-r.errc:
+		default:
+		}
+		r.Close()
+		return false
+	}
+	r.cur = row
+	if r.cols == nil {
+		r.cols = make([]string, 0, len(row))
+		for _, j := range row {
+			r.cols = append(r.cols, j.Name)
+		}
+	}
+	return true
+}
+
+// Scan copies the fields of the current row into the values pointed to by dest, the same way as ResultRows.Scan().
+// Unlike ResultRows.Scan(), numbers are scanned from their native values, and destinations of type *interface{} are
+// given int64, float64, or string values (or nil for NULL) rather than strings.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.closed || r.cur == nil {
+		return fmt.Errorf("Scan called without a successful call to Next")
+	}
+	if len(dest) != len(r.cur) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(r.cur), len(dest))
+	}
+
+	// Numbers are scanned from their native values, so large ones aren't mangled by their string form.  BLOBs are
+	// given as in Results, following the DefaultBlobBase64 setting of the connection
+	text := convertRow(r.cur, r.blobBase64).Fields
+	for i, j := range r.cur {
+		v := nativeValue(j)
+		if j.Type == com.Binary || j.Type == com.Image {
+			v = text[i]
+		}
+		if err := scanValue(dest[i], v); err != nil {
+			return fmt.Errorf("converting field %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ResultRows provides database/sql style iteration over query results, for reusing code written against *sql.Rows.
+// It differs from *sql.Rows in a few ways:
+//
+//   - The results are already fully retrieved, so iterating never causes network traffic and Err() is always nil
+//   - Every value is scanned from its string form in Results, so SQL NULLs can't be told apart from empty strings
+//   - Columns() only has the column names when the query returned at least one row
+type ResultRows struct {
+	res    Results
+	pos    int
+	closed bool
+}
+
+// SQLRows returns a database/sql style iterator over the results
+func (r Results) SQLRows() *ResultRows {
+	return &ResultRows{res: r, pos: -1}
+}
+
+// Close stops the iteration.  It's safe to call more than once
+func (r *ResultRows) Close() error {
+	r.closed = true
+	return nil
+}
+
+// Columns returns the names of the result columns
+func (r *ResultRows) Columns() ([]string, error) {
+	if r.closed {
+		return nil, fmt.Errorf("rows are closed")
+	}
+	return r.res.ColNames, nil
+}
+
+// Err returns the error encountered during iteration, which is always nil as the results are already retrieved
+func (r *ResultRows) Err() error {
+	return nil
+}
+
+// Next moves to the next row, returning false when there are no more rows
+func (r *ResultRows) Next() bool {
+	if r.closed {
+		return false
+	}
+	r.pos++
+	if r.pos >= len(r.res.Rows) {
+		r.closed = true
+		return false
+	}
+	return true
+}
+
+// Scan copies the fields of the current row into the values pointed to by dest, converting them from their string
+// form.  Supported destinations are pointers to strings, []byte, bools, integer and floating point types, and
+// interface{}, along with anything implementing sql.Scanner.
+func (r *ResultRows) Scan(dest ...interface{}) error {
+	if r.closed || r.pos < 0 || r.pos >= len(r.res.Rows) {
+		return fmt.Errorf("Scan called without a successful call to Next")
+	}
+	fields := r.res.Rows[r.pos].Fields
+	if len(dest) != len(fields) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(fields), len(dest))
+	}
+	for i, j := range fields {
+		if err := scanValue(dest[i], j); err != nil {
+			return fmt.Errorf("converting field %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ScanAll copies the rows of the results into dest, which needs to be a pointer to a slice of structs (or of pointers
+// to structs).  Columns are matched to struct fields using `dbhub:"column_name"` tags, falling back to a case
+// insensitive match on the field name for fields without a tag.  Fields tagged with `dbhub:"-"` are skipped, as are
+// columns with no matching field.  Values are converted the same way as with ResultRows.Scan().
+func (r Results) ScanAll(dest interface{}) (err error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("destination is not a non-nil pointer to a slice")
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("destination is not a slice of structs, but of '%s'", elemType)
+	}
+	if len(r.Rows) != 0 && len(r.ColNames) == 0 {
+		return fmt.Errorf("results have no column names")
+	}
+
+	// Work out which struct field each column goes into
+	fieldIdx := make([]int, len(r.ColNames))
+	for i, col := range r.ColNames {
+		fieldIdx[i] = scanField(structType, col)
+	}
+
+	// Convert the rows
+	rows := reflect.MakeSlice(slice.Type(), 0, len(r.Rows))
+	for i, row := range r.Rows {
+		if len(row.Fields) != len(r.ColNames) {
+			return fmt.Errorf("row %d has %d fields, but there are %d columns", i, len(row.Fields), len(r.ColNames))
+		}
+		s := reflect.New(structType)
+		for j, f := range row.Fields {
+			if fieldIdx[j] < 0 {
+				continue
+			}
+			field := s.Elem().Field(fieldIdx[j])
+			if field.Kind() == reflect.Ptr {
+				field.Set(reflect.New(field.Type().Elem()))
+				field = field.Elem()
+			}
+			err = scanValue(field.Addr().Interface(), f)
+			if err != nil {
+				return fmt.Errorf("row %d, column '%s': %w", i, r.ColNames[j], err)
+			}
+		}
+		if elemType.Kind() == reflect.Ptr {
+			rows = reflect.Append(rows, s)
+		} else {
+			rows = reflect.Append(rows, s.Elem())
+		}
+	}
+	slice.Set(rows)
+	return
+}
+
+// scanField returns the index of the exported struct field a column is copied into by ScanAll(), or -1 if there isn't
+// one
+func scanField(t reflect.Type, col string) int {
+	byName := -1
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag, ok := f.Tag.Lookup("dbhub")
+		if ok {
+			if tag == col {
+				return i
+			}
+			continue
+		}
+		if byName < 0 && strings.EqualFold(f.Name, col) {
+			byName = i
+		}
+	}
+	return byName
+}
+
+// scanValue converts a field into the value pointed to by dest.  The field is a native value as returned by
+// nativeValue(), or the string form of a field in Results.
+func scanValue(dest interface{}, v interface{}) (err error) {
+	if d, ok := dest.(sql.Scanner); ok {
+		return d.Scan(v)
+	}
+	if d, ok := dest.(*interface{}); ok {
+		*d = v
+		return
+	}
+	switch val := v.(type) {
+	case nil:
+		// NULLs are scanned as empty strings, as they are in Results
+		return scanString(dest, "")
+	case int64:
+		return scanInt(dest, val)
+	case float64:
+		return scanFloat(dest, val)
+	case []byte:
+		return scanString(dest, string(val))
+	case string:
+		return scanString(dest, val)
+	}
+	return fmt.Errorf("unsupported value type '%T'", v)
+}
+
+// scanFloat converts a floating point field into the value pointed to by dest
+func scanFloat(dest interface{}, f float64) (err error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("destination is not a non-nil pointer")
+	}
+	e := v.Elem()
+	switch e.Kind() {
+	case reflect.Float32, reflect.Float64:
+		e.SetFloat(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return fmt.Errorf("can't convert %v to '%s'", f, e.Type())
+		}
+		return scanInt(dest, int64(f))
+	default:
+		return scanString(dest, strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return
+}
+
+// scanInt converts an integer field into the value pointed to by dest
+func scanInt(dest interface{}, n int64) (err error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("destination is not a non-nil pointer")
+	}
+	e := v.Elem()
+	switch e.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if e.OverflowInt(n) {
+			return fmt.Errorf("value %d is out of range for '%s'", n, e.Type())
+		}
+		e.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || e.OverflowUint(uint64(n)) {
+			return fmt.Errorf("value %d is out of range for '%s'", n, e.Type())
+		}
+		e.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		e.SetFloat(float64(n))
+	case reflect.Bool:
+		e.SetBool(n != 0)
+	default:
+		return scanString(dest, strconv.FormatInt(n, 10))
+	}
+	return
+}
+
+// scanString converts a string field into the value pointed to by dest
+func scanString(dest interface{}, s string) (err error) {
+	switch d := dest.(type) {
+	case sql.Scanner:
+		return d.Scan(s)
+	case *string:
+		*d = s
+		return
+	case *[]byte:
+		*d = []byte(s)
+		return
+	case *interface{}:
+		*d = s
+		return
+	case *bool:
+		*d, err = strconv.ParseBool(s)
+		return
+	}
+
+	// Numeric types are handled using reflection, to cover all the sizes
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("destination is not a non-nil pointer")
+	}
+	e := v.Elem()
+	switch e.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, e.Type().Bits())
+		if err != nil {
+			return
+		}
+		e.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, e.Type().Bits())
+		if err != nil {
+			return
+		}
+		e.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(s, e.Type().Bits())
+		if err != nil {
+			return
+		}
+		e.SetFloat(f)
+	case reflect.String:
+		e.SetString(s)
+	default:
+		err = fmt.Errorf("unsupported destination type '%T'", dest)
+	}
+	return
+}
@@ -0,0 +1,177 @@
+package dbhub_test
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+func TestQueryRowsLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxRows int
+	}{
+		{"one row", 1},
+		{"several rows", 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dropped := newStreamingServer(t)
+			defer s.Close()
+			rows, err := newConnection(t, s).QueryRowsLimit("me", "db.sqlite", dbhub.Identifier{}, "SELECT n FROM big",
+				tt.maxRows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			n := 0
+			for n < tt.maxRows && rows.Next() {
+				var v int
+				if err = rows.Scan(&v); err != nil {
+					t.Fatal(err)
+				}
+				if v != n {
+					t.Errorf("row %d holds %d", n, v)
+				}
+				n++
+			}
+			if n != tt.maxRows {
+				t.Fatalf("got %d rows, want %d", n, tt.maxRows)
+			}
+
+			// The connection is dropped once the limit is reached, without waiting for Close()
+			waitDropped(t, dropped)
+			if rows.Next() {
+				t.Error("more rows returned after the limit")
+			}
+			if err = rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	const row = `[[{"Name":"i","Type":4,"Value":1000000},{"Name":"u","Type":4,"Value":1234567890123},` +
+		`{"Name":"f","Type":5,"Value":2.5},{"Name":"s","Type":3,"Value":"text"}]]`
+	type record struct {
+		I int
+		U uint64
+		F float64
+		S string
+	}
+	want := record{I: 1000000, U: 1234567890123, F: 2.5, S: "text"}
+	scanners := []struct {
+		name string
+		scan func(c dbhub.Connection) (got record, err error)
+	}{
+		{"Rows", func(c dbhub.Connection) (got record, err error) {
+			rows, err := c.QueryRows("me", "db.sqlite", dbhub.Identifier{}, "SELECT * FROM t")
+			if err != nil {
+				return
+			}
+			defer rows.Close()
+			if !rows.Next() {
+				err = fmt.Errorf("no rows returned: %v", rows.Err())
+				return
+			}
+			err = rows.Scan(&got.I, &got.U, &got.F, &got.S)
+			return
+		}},
+		{"ResultRows", func(c dbhub.Connection) (got record, err error) {
+			res, err := c.Query("me", "db.sqlite", dbhub.Identifier{}, false, "SELECT * FROM t")
+			if err != nil {
+				return
+			}
+			rows := res.SQLRows()
+			if !rows.Next() {
+				err = fmt.Errorf("no rows returned")
+				return
+			}
+			err = rows.Scan(&got.I, &got.U, &got.F, &got.S)
+			return
+		}},
+		{"ScanAll", func(c dbhub.Connection) (got record, err error) {
+			res, err := c.Query("me", "db.sqlite", dbhub.Identifier{}, false, "SELECT * FROM t")
+			if err != nil {
+				return
+			}
+			var all []record
+			err = res.ScanAll(&all)
+			if err == nil && len(all) != 1 {
+				err = fmt.Errorf("got %d records, want 1", len(all))
+			}
+			if err == nil {
+				got = all[0]
+			}
+			return
+		}},
+	}
+	for _, tt := range scanners {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("query", 200, row)
+			got, err := tt.scan(s.Connection())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRowsScanTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   string
+		dest    func() interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"integer into interface", `{"Name":"v","Type":4,"Value":1000000}`, func() interface{} { return new(interface{}) },
+			int64(1000000), false},
+		{"integer into string", `{"Name":"v","Type":4,"Value":1000000}`, func() interface{} { return new(string) },
+			"1000000", false},
+		{"integer into bool", `{"Name":"v","Type":4,"Value":1}`, func() interface{} { return new(bool) }, true, false},
+		{"integral float into int", `{"Name":"v","Type":5,"Value":3000000}`, func() interface{} { return new(int) },
+			3000000, false},
+		{"fractional float into int", `{"Name":"v","Type":5,"Value":2.5}`, func() interface{} { return new(int) },
+			nil, true},
+		{"negative into uint", `{"Name":"v","Type":4,"Value":-1}`, func() interface{} { return new(uint) }, nil, true},
+		{"overflow", `{"Name":"v","Type":4,"Value":300}`, func() interface{} { return new(int8) }, nil, true},
+		{"null into scanner", `{"Name":"v","Type":2,"Value":null}`, func() interface{} { return new(sql.NullInt64) },
+			sql.NullInt64{}, false},
+		{"null into interface", `{"Name":"v","Type":2,"Value":null}`, func() interface{} { return new(interface{}) },
+			nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("query", 200, "[["+tt.field+"]]")
+			rows, err := s.Connection().QueryRows("me", "db.sqlite", dbhub.Identifier{}, "SELECT v FROM t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			if !rows.Next() {
+				t.Fatalf("no rows returned: %v", rows.Err())
+			}
+			dest := tt.dest()
+			err = rows.Scan(dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got := reflect.ValueOf(dest).Elem().Interface(); !tt.wantErr && got != tt.want {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package dbhub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// CheckForeignKeys returns the rows of a database which violate one of its foreign key constraints (eg orphaned rows
+// referring to a parent row which no longer exists).  An empty list means all foreign keys are satisfied.
+func (c Connection) CheckForeignKeys(dbOwner, dbName string) (violations []FKViolation, err error) {
+	return c.CheckForeignKeysContext(context.Background(), dbOwner, dbName)
+}
+
+// CheckForeignKeysContext is like CheckForeignKeys, but uses the given context for the request
+func (c Connection) CheckForeignKeysContext(ctx context.Context, dbOwner, dbName string) (violations []FKViolation, err error) {
+	// The query end point only accepts SELECT statements, so the table valued form of the pragma is used
+	sql := `SELECT "table", "rowid", "parent", "fkid" FROM pragma_foreign_key_check()`
+	res, err := c.QueryTypedContext(ctx, dbOwner, dbName, Identifier{}, sql)
+	if err != nil {
+		return
+	}
+
+	// Convert the returned rows
+	for _, row := range res.Rows {
+		if len(row) != 4 {
+			err = fmt.Errorf("unexpected number of fields (%d) in foreign key check", len(row))
+			return
+		}
+		table, okTable := row[0].Value.(string)
+		rowID, okRowID := row[1].Value.(int64)
+		parent, okParent := row[2].Value.(string)
+		fkID, okFKID := row[3].Value.(int64)
+		if row[1].IsNull { // WITHOUT ROWID tables don't have a rowid
+			okRowID = true
+		}
+		if !okTable || !okRowID || !okParent || !okFKID {
+			err = fmt.Errorf("unexpected foreign key check row (%v, %v, %v, %v)", row[0].Value, row[1].Value,
+				row[2].Value, row[3].Value)
+			return
+		}
+		violations = append(violations, FKViolation{Table: table, RowID: rowID, Parent: parent, FKID: int(fkID)})
+	}
+	return
+}
+
+// ColumnsWithAffinity returns the column information for a given table or view, like Columns(), with the type affinity
+// of each column included
+func (c Connection) ColumnsWithAffinity(dbOwner, dbName string, ident Identifier, table string) (columns []ColumnInfo, err error) {
+	return c.ColumnsWithAffinityContext(context.Background(), dbOwner, dbName, ident, table)
+}
+
+// ColumnsWithAffinityContext is like ColumnsWithAffinity, but uses the given context for the request
+func (c Connection) ColumnsWithAffinityContext(ctx context.Context, dbOwner, dbName string, ident Identifier, table string) (columns []ColumnInfo, err error) {
+	cols, err := c.ColumnsContext(ctx, dbOwner, dbName, ident, table)
+	if err != nil {
+		return
+	}
+	for _, j := range cols {
+		columns = append(columns, ColumnInfo{APIJSONColumn: j, ColumnAffinity: TypeAffinity(j.DataType)})
+	}
+	return
+}
+
+// erMapConcurrency is the largest number of tables ERMap() retrieves the foreign keys of at any one time
+const erMapConcurrency = 4
+
+// ERMap returns the foreign key relationships of every table in a database, keyed by table name.  Tables without any
+// foreign keys are included with an empty list.  A few tables are queried concurrently, and if any of them fails the
+// requests still in progress are cancelled.
+func (c Connection) ERMap(dbOwner, dbName string) (rels map[string][]ForeignKey, err error) {
+	return c.ERMapContext(context.Background(), dbOwner, dbName)
+}
+
+// ERMapContext is like ERMap, but uses the given context for the requests
+func (c Connection) ERMapContext(ctx context.Context, dbOwner, dbName string) (rels map[string][]ForeignKey, err error) {
+	tables, err := c.TablesContext(ctx, dbOwner, dbName, Identifier{})
+	if err != nil {
+		return
+	}
+
+	// Retrieve the foreign keys for each table, with at most erMapConcurrency requests in progress at any one time.  The
+	// first failure cancels the others
+	fkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, erMapConcurrency)
+	rels = make(map[string][]ForeignKey, len(tables))
+	for _, tbl := range tables {
+		select {
+		case sem <- struct{}{}:
+		case <-fkCtx.Done():
+		}
+		if fkCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(tbl string) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			fks, e := c.ForeignKeysContext(fkCtx, dbOwner, dbName, tbl)
+			mu.Lock()
+			defer mu.Unlock()
+			if e != nil {
+				if err == nil {
+					err = fmt.Errorf("table '%s': %w", tbl, e)
+					cancel()
+				}
+				return
+			}
+			rels[tbl] = fks
+		}(tbl)
+	}
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		rels = nil
+	}
+	return
+}
+
+// ExpandStar returns the columns of a table as a comma separated list of quoted names, in table order.  It's useful
+// for replacing "*" in generated SELECT statements with an explicit column list.
+func (c Connection) ExpandStar(dbOwner, dbName, table string) (list string, err error) {
+	return c.ExpandStarContext(context.Background(), dbOwner, dbName, table)
+}
+
+// ExpandStarContext is like ExpandStar, but uses the given context for the request
+func (c Connection) ExpandStarContext(ctx context.Context, dbOwner, dbName, table string) (list string, err error) {
+	columns, err := c.ColumnsContext(ctx, dbOwner, dbName, Identifier{}, table)
+	if err != nil {
+		return
+	}
+	if len(columns) == 0 {
+		err = fmt.Errorf("no columns found for table '%s'", table)
+		return
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Cid < columns[j].Cid })
+	var names []string
+	for _, j := range columns {
+		names = append(names, j.Name)
+	}
+	list = quoteIdentifiers(names)
+	return
+}
+
+// ForeignKeys returns the foreign key constraints of a table.  The To field is empty when the foreign key refers to the
+// primary key of the referenced table.
+func (c Connection) ForeignKeys(dbOwner, dbName, table string) (fks []ForeignKey, err error) {
+	return c.ForeignKeysContext(context.Background(), dbOwner, dbName, table)
+}
+
+// ForeignKeysContext is like ForeignKeys, but uses the given context for the request
+func (c Connection) ForeignKeysContext(ctx context.Context, dbOwner, dbName, table string) (fks []ForeignKey, err error) {
+	// The query end point only accepts SELECT statements, so the table valued form of the pragma is used
+	t, err := quoteValue(table)
+	if err != nil {
+		return
+	}
+	sql := fmt.Sprintf(`SELECT "id", "seq", "table", "from", "to", "on_update", "on_delete"
+		FROM pragma_foreign_key_list(%s) ORDER BY "id", "seq"`, t)
+	res, err := c.QueryContext(ctx, dbOwner, dbName, Identifier{}, false, sql)
+	if err != nil {
+		return
+	}
+
+	// Convert the returned rows
+	for _, row := range res.Rows {
+		if len(row.Fields) != 7 {
+			err = fmt.Errorf("unexpected number of fields (%d) in foreign key list", len(row.Fields))
+			return
+		}
+		var fk ForeignKey
+		fk.ID, err = strconv.Atoi(row.Fields[0])
+		if err != nil {
+			return
+		}
+		fk.Seq, err = strconv.Atoi(row.Fields[1])
+		if err != nil {
+			return
+		}
+		fk.Table = row.Fields[2]
+		fk.From = row.Fields[3]
+		fk.To = row.Fields[4]
+		fk.OnUpdate = row.Fields[5]
+		fk.OnDelete = row.Fields[6]
+		fks = append(fks, fk)
+	}
+	return
+}
+
+// IntegrityCheck runs PRAGMA integrity_check on a database, to detect corruption.  If no problems are found ok is
+// true, otherwise the problems reported by SQLite are returned.
+func (c Connection) IntegrityCheck(dbOwner, dbName string) (ok bool, problems []string, err error) {
+	return c.IntegrityCheckContext(context.Background(), dbOwner, dbName)
+}
+
+// IntegrityCheckContext is like IntegrityCheck, but uses the given context for the request
+func (c Connection) IntegrityCheckContext(ctx context.Context, dbOwner, dbName string) (ok bool, problems []string, err error) {
+	// The query end point only accepts SELECT statements, so the table valued form of the pragma is used
+	res, err := c.QueryContext(ctx, dbOwner, dbName, Identifier{}, false, `SELECT "integrity_check" FROM pragma_integrity_check()`)
+	if err != nil {
+		return
+	}
+	if len(res.Rows) == 0 {
+		err = fmt.Errorf("no result returned by integrity check")
+		return
+	}
+	for _, row := range res.Rows {
+		if len(row.Fields) != 1 {
+			err = fmt.Errorf("unexpected number of fields (%d) in integrity check", len(row.Fields))
+			return
+		}
+		if row.Fields[0] != "ok" {
+			problems = append(problems, row.Fields[0])
+		}
+	}
+	ok = len(problems) == 0
+	return
+}
+
+// PrimaryKey returns the names of the primary key columns for a table, in primary key order.  Composite keys return
+// more than one column.  Tables without a declared primary key (eg plain rowid tables) return an empty list, in which
+// case the implicit "rowid" column can be used instead.
+func (c Connection) PrimaryKey(dbOwner, dbName, table string) (pk []string, err error) {
+	return c.PrimaryKeyContext(context.Background(), dbOwner, dbName, table)
+}
+
+// PrimaryKeyContext is like PrimaryKey, but uses the given context for the request
+func (c Connection) PrimaryKeyContext(ctx context.Context, dbOwner, dbName, table string) (pk []string, err error) {
+	// Retrieve the column details for the table
+	columns, err := c.ColumnsContext(ctx, dbOwner, dbName, Identifier{}, table)
+	if err != nil {
+		return
+	}
+
+	pk = primaryKey(columns)
+	return
+}
+
+// primaryKey returns the names of the primary key columns of a table, in primary key order
+func primaryKey(columns []com.APIJSONColumn) (pk []string) {
+	// The primary_key value of each column is its (1 based) position in the primary key, or 0 if it's not part of it
+	var pkCols []com.APIJSONColumn
+	for _, j := range columns {
+		if j.Pk > 0 {
+			pkCols = append(pkCols, j)
+		}
+	}
+	sort.Slice(pkCols, func(i, j int) bool { return pkCols[i].Pk < pkCols[j].Pk })
+	for _, j := range pkCols {
+		pk = append(pk, j.Name)
+	}
+	return
+}
+
+// SchemaDOT writes a Graphviz DOT graph of a database's schema to w.  Each table is a node listing its columns (with
+// their declared types), and each foreign key is an edge from the referencing table to the referenced one.
+func (c Connection) SchemaDOT(dbOwner, dbName string, w io.Writer) (err error) {
+	return c.SchemaDOTContext(context.Background(), dbOwner, dbName, w)
+}
+
+// SchemaDOTContext is like SchemaDOT, but uses the given context for the requests
+func (c Connection) SchemaDOTContext(ctx context.Context, dbOwner, dbName string, w io.Writer) (err error) {
+	rels, err := c.ERMapContext(ctx, dbOwner, dbName)
+	if err != nil {
+		return
+	}
+	var tables []string
+	for tbl := range rels {
+		tables = append(tables, tbl)
+	}
+	sort.Strings(tables)
+
+	// Add the tables
+	var b strings.Builder
+	b.WriteString("digraph schema {\n\tnode [shape=box];\n")
+	for _, tbl := range tables {
+		var columns []com.APIJSONColumn
+		columns, err = c.ColumnsContext(ctx, dbOwner, dbName, Identifier{}, tbl)
+		if err != nil {
+			return
+		}
+		sort.Slice(columns, func(i, j int) bool { return columns[i].Cid < columns[j].Cid })
+
+		// The table name is centred above the left justified column list
+		label := dotEscape(tbl) + `\n\n`
+		for _, j := range columns {
+			label += dotEscape(strings.TrimSpace(j.Name+" "+j.DataType)) + `\l`
+		}
+		fmt.Fprintf(&b, "\t%s [label=\"%s\"];\n", dotQuote(tbl), label)
+	}
+
+	// Add the foreign keys.  Multi-column foreign keys only get one edge, labelled with all of their columns
+	for _, tbl := range tables {
+		var ids []int
+		cols := make(map[int][]string)
+		parents := make(map[int]string)
+		for _, fk := range rels[tbl] {
+			if _, ok := parents[fk.ID]; !ok {
+				ids = append(ids, fk.ID)
+				parents[fk.ID] = fk.Table
+			}
+			col := fk.From
+			if fk.To != "" {
+				col += " -> " + fk.To
+			}
+			cols[fk.ID] = append(cols[fk.ID], col)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", dotQuote(tbl), dotQuote(parents[id]),
+				dotQuote(strings.Join(cols[id], ", ")))
+		}
+	}
+	b.WriteString("}\n")
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+// SetUserVersion changes the user version number (PRAGMA user_version) of a live database
+func (c Connection) SetUserVersion(dbOwner, dbName string, v int) (err error) {
+	return c.SetUserVersionContext(context.Background(), dbOwner, dbName, v)
+}
+
+// SetUserVersionContext is like SetUserVersion, but uses the given context for the request
+func (c Connection) SetUserVersionContext(ctx context.Context, dbOwner, dbName string, v int) (err error) {
+	_, err = c.ExecuteContext(ctx, dbOwner, dbName, fmt.Sprintf("PRAGMA user_version = %d", v))
+	return
+}
+
+// Stats returns the query planner statistics gathered by ANALYZE (the sqlite_stat1 table), keyed by index name.  Rows
+// for tables without indexes are keyed by table name instead.  The database needs to have been analysed already,
+// otherwise sqlite_stat1 won't exist.  For live databases, the statistics can be (re)generated by running "ANALYZE"
+// with Execute().
+func (c Connection) Stats(dbOwner, dbName string) (stats map[string]string, err error) {
+	return c.StatsContext(context.Background(), dbOwner, dbName)
+}
+
+// StatsContext is like Stats, but uses the given context for the request
+func (c Connection) StatsContext(ctx context.Context, dbOwner, dbName string) (stats map[string]string, err error) {
+	res, err := c.QueryContext(ctx, dbOwner, dbName, Identifier{}, false, `SELECT "tbl", "idx", "stat" FROM "sqlite_stat1"`)
+	if err != nil {
+		return
+	}
+	stats = make(map[string]string, len(res.Rows))
+	for _, row := range res.Rows {
+		if len(row.Fields) != 3 {
+			err = fmt.Errorf("unexpected number of fields (%d) in statistics", len(row.Fields))
+			return
+		}
+		key := row.Fields[1]
+		if key == "" {
+			key = row.Fields[0]
+		}
+		stats[key] = row.Fields[2]
+	}
+	return
+}
+
+// TableIndexes returns the indexes of a single table
+func (c Connection) TableIndexes(dbOwner, dbName, table string) (idx []com.APIJSONIndex, err error) {
+	return c.TableIndexesContext(context.Background(), dbOwner, dbName, table)
+}
+
+// TableIndexesContext is like TableIndexes, but uses the given context for the request
+func (c Connection) TableIndexesContext(ctx context.Context, dbOwner, dbName, table string) (idx []com.APIJSONIndex, err error) {
+	all, err := c.IndexesContext(ctx, dbOwner, dbName, Identifier{})
+	if err != nil {
+		return
+	}
+	for _, j := range all {
+		if j.Table == table {
+			idx = append(idx, j)
+		}
+	}
+	return
+}
+
+// UserVersion returns the user version number (PRAGMA user_version) of a database.  Applications often use this to
+// track their schema version.
+func (c Connection) UserVersion(dbOwner, dbName string) (v int, err error) {
+	return c.UserVersionContext(context.Background(), dbOwner, dbName)
+}
+
+// UserVersionContext is like UserVersion, but uses the given context for the request
+func (c Connection) UserVersionContext(ctx context.Context, dbOwner, dbName string) (v int, err error) {
+	res, err := c.QueryTypedContext(ctx, dbOwner, dbName, Identifier{}, "SELECT user_version FROM pragma_user_version")
+	if err != nil {
+		return
+	}
+	if len(res.Rows) != 1 || len(res.Rows[0]) != 1 {
+		err = fmt.Errorf("unexpected user version result")
+		return
+	}
+	n, ok := res.Rows[0][0].Value.(int64)
+	if !ok {
+		err = fmt.Errorf("unexpected user version value '%v'", res.Rows[0][0].Value)
+		return
+	}
+	v = int(n)
+	return
+}
+
+// TypeAffinity returns the type affinity SQLite gives a column with the given declared type (eg "VARCHAR(10)" is
+// AffinityText, and "DOUBLE" is AffinityReal).  The rules are applied in the same order SQLite uses.
+func TypeAffinity(declType string) Affinity {
+	t := strings.ToUpper(declType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return AffinityInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return AffinityText
+	case strings.Contains(t, "BLOB"), strings.TrimSpace(t) == "":
+		return AffinityBlob
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return AffinityReal
+	}
+	return AffinityNumeric
+}
+
+// dotEscape escapes a string for use inside a quoted Graphviz DOT ID
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// dotQuote returns a string as a quoted Graphviz DOT ID
+func dotQuote(s string) string {
+	return `"` + dotEscape(s) + `"`
+}
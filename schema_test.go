@@ -0,0 +1,260 @@
+package dbhub_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+func TestUserVersion(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{"zero", "0", 0},
+		{"small", "7", 7},
+		{"date based", "20200102", 20200102},
+		{"million", "1000000", 1000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("query", 200, `[[{"Name":"user_version","Type":4,"Value":`+tt.value+`}]]`)
+			got, err := s.Connection().UserVersion("me", "db.sqlite")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got user version %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetUserVersion(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("execute", 200, `{"rows_changed":0}`)
+	err := s.Connection().SetUserVersion("me", "live.sqlite", 1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := s.Requests()
+	if len(reqs) != 1 || reqs[0].Endpoint != "execute" {
+		t.Fatalf("got requests %+v", reqs)
+	}
+	b, _ := base64.StdEncoding.DecodeString(reqs[0].Form.Get("sql"))
+	if string(b) != "PRAGMA user_version = 1000000" {
+		t.Errorf("sent SQL %q", b)
+	}
+}
+
+func TestCheckForeignKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []dbhub.FKViolation
+	}{
+		{"no violations", `[]`, nil},
+		{"orphaned row", `[[{"Name":"table","Type":3,"Value":"child"},{"Name":"rowid","Type":4,"Value":1000000},` +
+			`{"Name":"parent","Type":3,"Value":"parent"},{"Name":"fkid","Type":4,"Value":0}]]`,
+			[]dbhub.FKViolation{{Table: "child", RowID: 1000000, Parent: "parent"}}},
+		{"without rowid table", `[[{"Name":"table","Type":3,"Value":"child"},{"Name":"rowid","Type":2,"Value":null},` +
+			`{"Name":"parent","Type":3,"Value":"parent"},{"Name":"fkid","Type":4,"Value":1}]]`,
+			[]dbhub.FKViolation{{Table: "child", Parent: "parent", FKID: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("query", 200, tt.response)
+			got, err := s.Connection().CheckForeignKeys("me", "db.sqlite")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got violations %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrimaryKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns string
+		want    []string
+	}{
+		{"single column", `[{"column_id":0,"name":"id","primary_key":1},{"column_id":1,"name":"name","primary_key":0}]`,
+			[]string{"id"}},
+		{"composite out of column order", `[{"column_id":0,"name":"a","primary_key":2},` +
+			`{"column_id":1,"name":"b","primary_key":0},{"column_id":2,"name":"c","primary_key":1}]`, []string{"c", "a"}},
+		{"no primary key", `[{"column_id":0,"name":"a","primary_key":0},{"column_id":1,"name":"b","primary_key":0}]`,
+			nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("columns", 200, tt.columns)
+			got, err := s.Connection().PrimaryKey("me", "db.sqlite", "t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if reqs := s.Requests(); len(reqs) != 1 || reqs[0].Form.Get("table") != "t" {
+				t.Errorf("got requests %+v", reqs)
+			}
+		})
+	}
+}
+
+func TestTypeAffinity(t *testing.T) {
+	tests := []struct {
+		declType string
+		want     dbhub.Affinity
+	}{
+		{"INTEGER", dbhub.AffinityInteger},
+		{"TINYINT", dbhub.AffinityInteger},
+		{"VARCHAR(10)", dbhub.AffinityText},
+		{"nvarchar(100)", dbhub.AffinityText},
+		{"CLOB", dbhub.AffinityText},
+		{"BLOB", dbhub.AffinityBlob},
+		{"", dbhub.AffinityBlob},
+		{"DOUBLE", dbhub.AffinityReal},
+		{"DOUBLE PRECISION", dbhub.AffinityReal},
+		{"FLOAT", dbhub.AffinityReal},
+		{"NUMERIC", dbhub.AffinityNumeric},
+		{"DECIMAL(10,5)", dbhub.AffinityNumeric},
+		{"DATETIME", dbhub.AffinityNumeric},
+
+		// The INT rule is checked first, so "FLOATING POINT" is an integer type as "POINT" contains "INT"
+		{"FLOATING POINT", dbhub.AffinityInteger},
+		{"CHARINT", dbhub.AffinityInteger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.declType, func(t *testing.T) {
+			if got := dbhub.TypeAffinity(tt.declType); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnsWithAffinity(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("columns", 200, `[{"column_id":0,"name":"name","data_type":"VARCHAR(10)"},`+
+		`{"column_id":1,"name":"price","data_type":"DOUBLE"},{"column_id":2,"name":"data","data_type":""}]`)
+	columns, err := s.Connection().ColumnsWithAffinity("me", "db.sqlite", dbhub.Identifier{}, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []dbhub.Affinity
+	for _, j := range columns {
+		got = append(got, j.ColumnAffinity)
+	}
+	want := []dbhub.Affinity{dbhub.AffinityText, dbhub.AffinityReal, dbhub.AffinityBlob}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got affinities %v, want %v", got, want)
+	}
+	if len(columns) != 3 || columns[0].Name != "name" || columns[1].DataType != "DOUBLE" {
+		t.Errorf("got columns %+v", columns)
+	}
+}
+
+// newERMapServer starts a server for a database with the given number of tables, answering the foreign key query of
+// each table with the handler
+func newERMapServer(t *testing.T, tables int, fkQuery func(w http.ResponseWriter, r *http.Request, sql string)) *httptest.Server {
+	t.Helper()
+	var names []string
+	for i := 0; i < tables; i++ {
+		names = append(names, fmt.Sprintf("%q", fmt.Sprintf("t%d", i)))
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path == "/v1/tables" {
+			fmt.Fprintf(w, "[%s]", strings.Join(names, ","))
+			return
+		}
+		b, err := base64.StdEncoding.DecodeString(r.PostForm.Get("sql"))
+		if err != nil {
+			t.Errorf("decoding the SQL of a query: %v", err)
+		}
+		fkQuery(w, r, string(b))
+	}))
+}
+
+func TestERMapConcurrency(t *testing.T) {
+	var inFlight, most int32
+	s := newERMapServer(t, 20, func(w http.ResponseWriter, r *http.Request, sql string) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		fmt.Fprint(w, "[]")
+	})
+	defer s.Close()
+	rels, err := newConnection(t, s).ERMap("me", "db.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rels) != 20 {
+		t.Errorf("got relationships for %d tables, want 20", len(rels))
+	}
+	if m := atomic.LoadInt32(&most); m > 4 {
+		t.Errorf("%d foreign key queries were in progress at once, want at most 4", m)
+	}
+}
+
+func TestERMapCancelsOnError(t *testing.T) {
+	var reqs int32
+	s := newERMapServer(t, 20, func(w http.ResponseWriter, r *http.Request, sql string) {
+		atomic.AddInt32(&reqs, 1)
+		if strings.Contains(sql, "'t1'") {
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"error":"no such table"}`)
+			return
+		}
+
+		// The other tables don't get an answer until their requests are cancelled
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		fmt.Fprint(w, "[]")
+	})
+	defer s.Close()
+	start := time.Now()
+	rels, err := newConnection(t, s).ERMap("me", "db.sqlite")
+	if err == nil || !strings.Contains(err.Error(), "table 't1'") {
+		t.Errorf("got error %v, want the one for table t1", err)
+	}
+	if rels != nil {
+		t.Errorf("got relationships %v along with the error", rels)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("returned after %v, so the other requests weren't cancelled", d)
+	}
+	if n := atomic.LoadInt32(&reqs); n >= 20 {
+		t.Errorf("all %d tables were queried despite the failure", n)
+	}
+}
@@ -0,0 +1,96 @@
+package dbhub
+
+import (
+	"context"
+)
+
+// Fork makes a copy of a database in your own account, returning the owner and name of the new database.  The copy
+// keeps the history of the original, so changes can later be merged back.
+func (c Connection) Fork(dbOwner, dbName string) (newOwner, newName string, err error) {
+	return c.ForkContext(context.Background(), dbOwner, dbName)
+}
+
+// ForkContext is like Fork, but uses the given context for the request
+func (c Connection) ForkContext(ctx context.Context, dbOwner, dbName string) (newOwner, newName string, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Fork the database
+	var response struct {
+		Owner  string `json:"dbowner"`
+		DBName string `json:"dbname"`
+	}
+	queryUrl := c.apiURL("fork")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &response)
+	if err != nil {
+		return
+	}
+	newOwner = response.Owner
+	newName = response.DBName
+	return
+}
+
+// Star adds a star to a database.  It's not an error if the database is already starred
+func (c Connection) Star(dbOwner, dbName string) (err error) {
+	return c.StarContext(context.Background(), dbOwner, dbName)
+}
+
+// StarContext is like Star, but uses the given context for the request
+func (c Connection) StarContext(ctx context.Context, dbOwner, dbName string) (err error) {
+	return c.sendSimple(ctx, "star", dbOwner, dbName)
+}
+
+// Unstar removes your star from a database
+func (c Connection) Unstar(dbOwner, dbName string) (err error) {
+	return c.UnstarContext(context.Background(), dbOwner, dbName)
+}
+
+// UnstarContext is like Unstar, but uses the given context for the request
+func (c Connection) UnstarContext(ctx context.Context, dbOwner, dbName string) (err error) {
+	return c.sendSimple(ctx, "unstar", dbOwner, dbName)
+}
+
+// Unwatch stops watching a database
+func (c Connection) Unwatch(dbOwner, dbName string) (err error) {
+	return c.UnwatchContext(context.Background(), dbOwner, dbName)
+}
+
+// UnwatchContext is like Unwatch, but uses the given context for the request
+func (c Connection) UnwatchContext(ctx context.Context, dbOwner, dbName string) (err error) {
+	return c.sendSimple(ctx, "unwatch", dbOwner, dbName)
+}
+
+// Watch starts watching a database, so you're notified by DBHub.io of changes to it.  It's not an error if the
+// database is already being watched
+func (c Connection) Watch(dbOwner, dbName string) (err error) {
+	return c.WatchContext(context.Background(), dbOwner, dbName)
+}
+
+// WatchContext is like Watch, but uses the given context for the request
+func (c Connection) WatchContext(ctx context.Context, dbOwner, dbName string) (err error) {
+	return c.sendSimple(ctx, "watch", dbOwner, dbName)
+}
+
+// Webhooks returns the webhooks registered for a database
+func (c Connection) Webhooks(dbOwner, dbName string) (hooks []Webhook, err error) {
+	return c.WebhooksContext(context.Background(), dbOwner, dbName)
+}
+
+// WebhooksContext is like Webhooks, but uses the given context for the request
+func (c Connection) WebhooksContext(ctx context.Context, dbOwner, dbName string) (hooks []Webhook, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+
+	// Fetch the list of webhooks
+	queryUrl := c.apiURL("webhooks")
+	err = c.sendRequestJSON(ctx, queryUrl, data, &hooks)
+	return
+}
+
+// sendSimple sends a request about a database to an API end point which doesn't return anything
+func (c Connection) sendSimple(ctx context.Context, endpoint, dbOwner, dbName string) (err error) {
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	queryUrl := c.apiURL(endpoint)
+	err = c.sendRequestJSON(ctx, queryUrl, data, nil)
+	return
+}
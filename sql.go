@@ -0,0 +1,306 @@
+package dbhub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BindArgs returns a SQL statement with its "?" and "?NNN" placeholders replaced by the given values, safely quoted
+// as SQLite literals.  Values for named placeholders (":name", "@name", or "$name") are given with Named().  It's
+// useful for building statements to run with Query() or Execute().
+func BindArgs(sql string, args ...interface{}) (string, error) {
+	return bindArgs(sql, args)
+}
+
+// Named returns a value for the named placeholder in a SQL statement with the given name, for use with BindArgs() and
+// QueryWithParams().  The name can be given with or without its leading ":", "@", or "$".
+func Named(name string, value interface{}) NamedArg {
+	return NamedArg{Name: strings.TrimLeft(name, ":@$"), Value: value}
+}
+
+// bindArgs replaces the "?", "?NNN", and named placeholders in a SQL statement with the given values, quoted as
+// SQLite literals.  Placeholders inside string literals, quoted identifiers, and comments are left alone.
+func bindArgs(sql string, args []interface{}) (string, error) {
+	// Split the named arguments from the positional ones, keeping track of where each came from for error messages
+	var positional []int
+	named := make(map[string]int)
+	for n, j := range args {
+		if a, ok := j.(NamedArg); ok {
+			named[a.Name] = n
+			continue
+		}
+		positional = append(positional, n)
+	}
+
+	var b strings.Builder
+	next := 0
+	used := make([]bool, len(args))
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		switch ch {
+		case '\'', '"', '`', '[':
+			// Copy quoted text through unchanged
+			end := quotedEnd(sql, i)
+			b.WriteString(sql[i:end])
+			i = end - 1
+		case '-', '/':
+			// Copy comments through unchanged
+			end := commentEnd(sql, i)
+			if end == i {
+				b.WriteByte(ch)
+				continue
+			}
+			b.WriteString(sql[i:end])
+			i = end - 1
+		case '?':
+			// Work out which argument the placeholder refers to
+			j := i + 1
+			for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+				j++
+			}
+			p := next
+			if j > i+1 {
+				num, err := strconv.Atoi(sql[i+1 : j])
+				if err != nil || num < 1 {
+					return "", fmt.Errorf("invalid placeholder '%s'", sql[i:j])
+				}
+				p = num - 1
+			}
+			if p >= len(positional) {
+				return "", fmt.Errorf("not enough arguments for the placeholders in the SQL")
+			}
+			n := positional[p]
+			v, err := quoteValue(args[n])
+			if err != nil {
+				return "", fmt.Errorf("argument %d: %w", n+1, err)
+			}
+			b.WriteString(v)
+			used[n] = true
+			next = p + 1
+			i = j - 1
+		case ':', '@', '$':
+			// Named placeholders start with one of these characters, which can't otherwise start a word
+			j := i + 1
+			for j < len(sql) && isWordChar(sql[j]) {
+				j++
+			}
+			if j == i+1 || (i > 0 && isWordChar(sql[i-1])) {
+				b.WriteByte(ch)
+				continue
+			}
+			n, ok := named[sql[i+1:j]]
+			if !ok {
+				return "", fmt.Errorf("no argument given for placeholder '%s'", sql[i:j])
+			}
+			v, err := quoteValue(args[n].(NamedArg).Value)
+			if err != nil {
+				return "", fmt.Errorf("argument '%s': %w", sql[i+1:j], err)
+			}
+			b.WriteString(v)
+			used[n] = true
+			i = j - 1
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	for n, u := range used {
+		if u {
+			continue
+		}
+		if a, ok := args[n].(NamedArg); ok {
+			return "", fmt.Errorf("argument '%s' isn't used by any placeholder", a.Name)
+		}
+		return "", fmt.Errorf("argument %d isn't used by any placeholder", n+1)
+	}
+	return b.String(), nil
+}
+
+// commentEnd returns the position just after the comment starting at position i of the SQL, or i if there's no comment
+// starting there
+func commentEnd(sql string, i int) int {
+	if strings.HasPrefix(sql[i:], "--") {
+		end := strings.IndexByte(sql[i:], '\n')
+		if end == -1 {
+			return len(sql)
+		}
+		return i + end + 1
+	}
+	if strings.HasPrefix(sql[i:], "/*") {
+		end := strings.Index(sql[i+2:], "*/")
+		if end == -1 {
+			return len(sql)
+		}
+		return i + 2 + end + 2
+	}
+	return i
+}
+
+// quotedEnd returns the position just after the quoted string or identifier starting at position i of the SQL.
+// Doubled quote characters inside the quotes are treated as escaped quotes
+func quotedEnd(sql string, i int) int {
+	closing := sql[i]
+	if closing == '[' {
+		closing = ']'
+	}
+	for j := i + 1; j < len(sql); j++ {
+		if sql[j] != closing {
+			continue
+		}
+		if closing != ']' && j+1 < len(sql) && sql[j+1] == closing {
+			j++
+			continue
+		}
+		return j + 1
+	}
+	return len(sql)
+}
+
+// quoteIdentifier returns a SQLite identifier (eg a table or column name) in double quoted form, safe for including
+// in generated SQL
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteIdentifiers returns a comma separated list of quoted SQLite identifiers
+func quoteIdentifiers(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, j := range names {
+		quoted = append(quoted, quoteIdentifier(j))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// quoteValue returns a Go value as a SQLite literal, safe for including in generated SQL
+func quoteValue(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return "'" + strings.ReplaceAll(val, "'", "''") + "'", nil
+	case []byte:
+		return "X'" + hex.EncodeToString(val) + "'", nil
+	case bool:
+		if val {
+			return "1", nil
+		}
+		return "0", nil
+	case int:
+		return strconv.FormatInt(int64(val), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(val), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(val), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(val), 10), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(val), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(val), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(val), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(val), 10), nil
+	case uint64:
+		return strconv.FormatUint(val, 10), nil
+	case float32:
+		return quoteFloat(float64(val))
+	case float64:
+		return quoteFloat(val)
+	case time.Time:
+		return "'" + val.Format(time.RFC3339Nano) + "'", nil
+	default:
+		return "", fmt.Errorf("unsupported value type '%T'", v)
+	}
+}
+
+// quoteFloat returns a floating point value as a SQLite literal.  SQLite has no literal form for NaN or infinity, so
+// those are rejected
+func quoteFloat(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("unsupported floating point value '%v'", f)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), nil
+}
+
+// splitStatements splits a SQL script into its individual statements, without the terminating semicolons.  Semicolons
+// inside string literals, quoted identifiers, comments, and the BEGIN ... END body of CREATE TRIGGER statements don't
+// end a statement.  Empty statements are skipped.
+func splitStatements(script string) (stmts []string) {
+	start := 0
+	depth := 0        // Nesting level of BEGIN/CASE ... END blocks
+	var lead []string // The first few words of the current statement, for recognising CREATE TRIGGER
+	hasContent := false
+	isTrigger := func() bool {
+		if len(lead) >= 2 && lead[0] == "CREATE" && lead[1] == "TRIGGER" {
+			return true
+		}
+		return len(lead) >= 3 && lead[0] == "CREATE" && (lead[1] == "TEMP" || lead[1] == "TEMPORARY") &&
+			lead[2] == "TRIGGER"
+	}
+	for i := 0; i < len(script); i++ {
+		ch := script[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`' || ch == '[':
+			i = quotedEnd(script, i) - 1
+			hasContent = true
+		case (ch == '-' || ch == '/') && commentEnd(script, i) != i:
+			i = commentEnd(script, i) - 1
+		case ch == ';':
+			if depth > 0 {
+				continue
+			}
+			if hasContent {
+				stmts = append(stmts, strings.TrimSpace(script[start:i]))
+			}
+			start = i + 1
+			lead = nil
+			hasContent = false
+		case isWordChar(ch):
+			j := i
+			for j < len(script) && isWordChar(script[j]) {
+				j++
+			}
+			word := strings.ToUpper(script[i:j])
+			if len(lead) < 3 {
+				lead = append(lead, word)
+			}
+			switch word {
+			case "BEGIN":
+				if isTrigger() {
+					depth++
+				}
+			case "CASE":
+				depth++
+			case "END":
+				if depth > 0 {
+					depth--
+				}
+			}
+			i = j - 1
+			hasContent = true
+		case ch != ' ' && ch != '\t' && ch != '\r' && ch != '\n':
+			hasContent = true
+		}
+	}
+	if hasContent {
+		stmts = append(stmts, strings.TrimSpace(script[start:]))
+	}
+	return
+}
+
+// isWordChar returns true for characters which can be part of an unquoted SQL keyword or identifier
+func isWordChar(ch byte) bool {
+	return ch == '_' || ch == '$' || ch >= 0x80 || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') ||
+		(ch >= '0' && ch <= '9')
+}
+
+// trimStatement removes surrounding whitespace and any trailing semicolons from a SQL statement
+func trimStatement(sql string) string {
+	return strings.TrimRight(strings.TrimSpace(sql), "; \t\r\n")
+}
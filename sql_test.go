@@ -0,0 +1,73 @@
+package dbhub_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+)
+
+func TestBindArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		args []interface{}
+		want string
+	}{
+		{"no placeholders", "SELECT 1", nil, "SELECT 1"},
+		{"positional", "SELECT * FROM t WHERE a = ? AND b = ?", []interface{}{1, "x"},
+			"SELECT * FROM t WHERE a = 1 AND b = 'x'"},
+		{"numbered", "SELECT ?2, ?1, ?", []interface{}{"a", "b"}, "SELECT 'b', 'a', 'b'"},
+		{"named", "SELECT :a, @b, $c", []interface{}{dbhub.Named("a", 1), dbhub.Named(":b", 2), dbhub.Named("$c", 3)},
+			"SELECT 1, 2, 3"},
+		{"repeated name", "SELECT :a + :a", []interface{}{dbhub.Named("a", 2)}, "SELECT 2 + 2"},
+		{"mixed", "SELECT ?, :n", []interface{}{dbhub.Named("n", 2), 1}, "SELECT 1, 2"},
+		{"quotes escaped", "SELECT ?", []interface{}{"it's"}, "SELECT 'it''s'"},
+		{"placeholders in strings", `SELECT '?', "?", [?], ` + "`?`" + `, ?`, []interface{}{1},
+			`SELECT '?', "?", [?], ` + "`?`" + `, 1`},
+		{"escaped quote in string", "SELECT 'a''?', ?", []interface{}{1}, "SELECT 'a''?', 1"},
+		{"placeholders in comments", "SELECT ? -- ?\n/* ? */", []interface{}{1}, "SELECT 1 -- ?\n/* ? */"},
+		{"not a name", "SELECT a:b, c@d, ?", []interface{}{1}, "SELECT a:b, c@d, 1"},
+		{"types", "SELECT ?, ?, ?, ?, ?, ?", []interface{}{nil, true, []byte{0xab, 0x01}, 2.5, uint8(7),
+			time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+			"SELECT NULL, 1, X'ab01', 2.5, 7, '2020-01-02T03:04:05Z'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dbhub.BindArgs(tt.sql, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindArgsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		args []interface{}
+	}{
+		{"not enough arguments", "SELECT ?, ?", []interface{}{1}},
+		{"number out of range", "SELECT ?3", []interface{}{1, 2}},
+		{"number zero", "SELECT ?0", []interface{}{1}},
+		{"unused argument", "SELECT ?", []interface{}{1, 2}},
+		{"unused named argument", "SELECT ?", []interface{}{1, dbhub.Named("a", 2)}},
+		{"missing named argument", "SELECT :a", nil},
+		{"unsupported type", "SELECT ?", []interface{}{struct{}{}}},
+		{"NaN", "SELECT ?", []interface{}{math.NaN()}},
+		{"infinity", "SELECT ?", []interface{}{math.Inf(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dbhub.BindArgs(tt.sql, tt.args...)
+			if err == nil {
+				t.Errorf("no error returned, got %s", got)
+			}
+		})
+	}
+}
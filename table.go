@@ -0,0 +1,469 @@
+package dbhub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// eachRowPageSize is the number of rows retrieved at a time by EachRow()
+const eachRowPageSize = 1000
+
+// BlobReader returns a reader for the BLOB stored in one row of a table, chosen by the value of the pkColumn column.
+// The raw bytes are streamed from the server's BLOB end point when available, otherwise the BLOB is retrieved with a
+// query.  A NULL value gives an empty reader, while a value of another type (eg an integer) returns an error.  The
+// reader must be closed after use.
+func (c Connection) BlobReader(dbOwner, dbName, table, pkColumn string, pk interface{}, blobColumn string) (blob io.ReadCloser, err error) {
+	return c.BlobReaderContext(context.Background(), dbOwner, dbName, table, pkColumn, pk, blobColumn)
+}
+
+// BlobReaderContext is like BlobReader, but uses the given context for the requests
+func (c Connection) BlobReaderContext(ctx context.Context, dbOwner, dbName, table, pkColumn string, pk interface{}, blobColumn string) (blob io.ReadCloser, err error) {
+	// Prepare the API parameters
+	data := c.PrepareVals(dbOwner, dbName, Identifier{})
+	data.Set("table", table)
+	data.Set("column", blobColumn)
+	data.Set("pkcolumn", pkColumn)
+	data.Set("pk", fmt.Sprint(pk))
+
+	// Try the BLOB end point first
+	var resp *http.Response
+	queryUrl := c.apiURL("blob")
+	resp, err = c.doRequest(ctx, queryUrl, data)
+	if err != nil {
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		blob = resp.Body
+		return
+	case http.StatusNotFound, http.StatusNotImplemented:
+		// The server doesn't support streaming BLOBs, so fall back to a query
+		resp.Body.Close()
+	default:
+		err = responseError(resp)
+		resp.Body.Close()
+		return
+	}
+	v, err := c.CellContext(ctx, dbOwner, dbName, table, blobColumn, pkColumn, pk)
+	if err != nil {
+		return
+	}
+	switch b := v.(type) {
+	case []byte:
+		blob = ioutil.NopCloser(bytes.NewReader(b))
+	case nil:
+		blob = ioutil.NopCloser(bytes.NewReader(nil))
+	default:
+		err = fmt.Errorf("column '%s' holds a %T rather than a BLOB", blobColumn, v)
+	}
+	return
+}
+
+// Cell returns the value of a column in the row of a table where pkColumn equals pk.  The value is returned as an
+// int64, float64, string, []byte, or nil for NULL.  If there's no such row, ErrNoRows is returned.  An error is also
+// returned if more than one row matches.
+func (c Connection) Cell(dbOwner, dbName, table, column, pkColumn string, pk interface{}) (value interface{}, err error) {
+	return c.CellContext(context.Background(), dbOwner, dbName, table, column, pkColumn, pk)
+}
+
+// CellContext is like Cell, but uses the given context for the request
+func (c Connection) CellContext(ctx context.Context, dbOwner, dbName, table, column, pkColumn string, pk interface{}) (value interface{}, err error) {
+	sql, err := bindArgs(fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? LIMIT 2", quoteIdentifier(column),
+		quoteIdentifier(table), quoteIdentifier(pkColumn)), []interface{}{pk})
+	if err != nil {
+		return
+	}
+	rows, err := c.querySQLRaw(ctx, dbOwner, dbName, Identifier{}, sql)
+	if err != nil {
+		return
+	}
+	switch {
+	case len(rows) == 0:
+		err = ErrNoRows
+		return
+	case len(rows) > 1:
+		err = fmt.Errorf("more than one row has %s = %v", pkColumn, pk)
+		return
+	case len(rows[0]) != 1:
+		err = fmt.Errorf("unexpected number of fields (%d) returned", len(rows[0]))
+		return
+	}
+	value = nativeValue(rows[0][0])
+	return
+}
+
+// ColumnTypeHistogram returns the number of values of each SQLite storage class ("integer", "real", "text", "blob",
+// and "null") held in a column.  SQLite allows values of any type in most columns, so this is useful for finding
+// unexpected data.
+func (c Connection) ColumnTypeHistogram(dbOwner, dbName, table, column string) (hist map[string]int64, err error) {
+	return c.ColumnTypeHistogramContext(context.Background(), dbOwner, dbName, table, column)
+}
+
+// ColumnTypeHistogramContext is like ColumnTypeHistogram, but uses the given context for the request
+func (c Connection) ColumnTypeHistogramContext(ctx context.Context, dbOwner, dbName, table, column string) (hist map[string]int64, err error) {
+	sql := fmt.Sprintf("SELECT typeof(%s), COUNT(*) FROM %s GROUP BY 1", quoteIdentifier(column),
+		quoteIdentifier(table))
+	res, err := c.QueryTypedContext(ctx, dbOwner, dbName, Identifier{}, sql)
+	if err != nil {
+		return
+	}
+	hist = make(map[string]int64, len(res.Rows))
+	for _, row := range res.Rows {
+		if len(row) != 2 {
+			err = fmt.Errorf("unexpected number of fields (%d) in type histogram", len(row))
+			return
+		}
+		class, ok := row[0].Value.(string)
+		n, isInt := row[1].Value.(int64)
+		if !ok || !isInt {
+			err = fmt.Errorf("unexpected type histogram row (%v, %v)", row[0].Value, row[1].Value)
+			return
+		}
+		hist[class] = n
+	}
+	return
+}
+
+// ColumnChanges returns the individual values of a table which were changed between two commits of a database.  Only
+// rows present in both commits are included, so inserted and deleted rows aren't reported.  Values are returned as
+// int64, float64, string, []byte, or nil for NULL.
+func (c Connection) ColumnChanges(dbOwner, dbName, table, commitA, commitB string) (changes []CellChange, err error) {
+	return c.ColumnChangesContext(context.Background(), dbOwner, dbName, table, commitA, commitB)
+}
+
+// ColumnChangesContext is like ColumnChanges, but uses the given context for the requests
+func (c Connection) ColumnChangesContext(ctx context.Context, dbOwner, dbName, table, commitA, commitB string) (changes []CellChange, err error) {
+	diffs, err := c.DiffContext(ctx, dbOwner, dbName, Identifier{CommitID: commitA}, "", "", Identifier{CommitID: commitB}, NoMerge)
+	if err != nil {
+		return
+	}
+	for _, obj := range diffs.Diff {
+		if obj.ObjectName != table || obj.ObjectType != "table" {
+			continue
+		}
+		for _, row := range obj.Data {
+			if row.ActionType != com.ActionModify {
+				continue
+			}
+
+			// Retrieve the row as it was in each commit, and compare the values
+			pk := make(map[string]interface{}, len(row.Pk))
+			var where []string
+			var args []interface{}
+			for _, j := range row.Pk {
+				pk[j.Name] = nativeValue(j)
+				where = append(where, quoteIdentifier(j.Name)+" = ?")
+				args = append(args, nativeValue(j))
+			}
+			var sql string
+			sql, err = bindArgs(fmt.Sprintf("SELECT * FROM %s WHERE %s", quoteIdentifier(table),
+				strings.Join(where, " AND ")), args)
+			if err != nil {
+				return
+			}
+			var before, after []com.DataRow
+			before, err = c.querySQLRaw(ctx, dbOwner, dbName, Identifier{CommitID: commitA}, sql)
+			if err != nil {
+				return
+			}
+			after, err = c.querySQLRaw(ctx, dbOwner, dbName, Identifier{CommitID: commitB}, sql)
+			if err != nil {
+				return
+			}
+			if len(before) != 1 || len(after) != 1 {
+				err = fmt.Errorf("changed row %v of table '%s' couldn't be retrieved from both commits", pk, table)
+				return
+			}
+
+			// The values are matched up by column name, in case columns were added or removed between the commits
+			oldValues := make(map[string]interface{}, len(before[0]))
+			for _, j := range before[0] {
+				oldValues[j.Name] = nativeValue(j)
+			}
+			for _, j := range after[0] {
+				oldValue, newValue := oldValues[j.Name], nativeValue(j)
+				if !reflect.DeepEqual(oldValue, newValue) {
+					changes = append(changes, CellChange{PK: pk, Column: j.Name, Old: oldValue, New: newValue})
+				}
+			}
+		}
+	}
+	return
+}
+
+// CountWhere returns the number of rows in a table matching a WHERE clause.  The clause can contain "?" placeholders,
+// which are replaced with the safely quoted args.  An empty clause counts all rows.
+func (c Connection) CountWhere(dbOwner, dbName, table, where string, args ...interface{}) (count int64, err error) {
+	return c.CountWhereContext(context.Background(), dbOwner, dbName, table, where, args...)
+}
+
+// CountWhereContext is like CountWhere, but uses the given context for the request
+func (c Connection) CountWhereContext(ctx context.Context, dbOwner, dbName, table, where string, args ...interface{}) (count int64, err error) {
+	if table == "" {
+		err = fmt.Errorf("no table name given")
+		return
+	}
+	sql := "SELECT COUNT(*) FROM " + quoteIdentifier(table)
+	if strings.TrimSpace(where) != "" {
+		sql += " WHERE " + where
+	}
+	sql, err = bindArgs(sql, args)
+	if err != nil {
+		return
+	}
+	return c.queryCount(ctx, dbOwner, dbName, sql)
+}
+
+// DetectBlobType returns the MIME type of the BLOB stored in one row of a table, as determined by
+// http.DetectContentType().  The row is chosen by the value of the pkColumn column.  Only the first 512 bytes of the
+// BLOB are retrieved.
+func (c Connection) DetectBlobType(dbOwner, dbName, table, pkColumn string, pk interface{}, blobColumn string) (mimeType string, err error) {
+	return c.DetectBlobTypeContext(context.Background(), dbOwner, dbName, table, pkColumn, pk, blobColumn)
+}
+
+// DetectBlobTypeContext is like DetectBlobType, but uses the given context for the request
+func (c Connection) DetectBlobTypeContext(ctx context.Context, dbOwner, dbName, table, pkColumn string, pk interface{}, blobColumn string) (mimeType string, err error) {
+	v, err := quoteValue(pk)
+	if err != nil {
+		return
+	}
+	sql := fmt.Sprintf("SELECT substr(CAST(%s AS BLOB), 1, 512) FROM %s WHERE %s = %s", quoteIdentifier(blobColumn),
+		quoteIdentifier(table), quoteIdentifier(pkColumn), v)
+	res, err := c.QueryContext(ctx, dbOwner, dbName, Identifier{}, true, sql)
+	if err != nil {
+		return
+	}
+	if len(res.Rows) == 0 || len(res.Rows[0].Fields) == 0 {
+		err = fmt.Errorf("no row found with %s = %s", pkColumn, v)
+		return
+	}
+
+	// The BLOB data is returned base64 encoded
+	prefix, err := base64.StdEncoding.DecodeString(res.Rows[0].Fields[0])
+	if err != nil {
+		return
+	}
+	mimeType = http.DetectContentType(prefix)
+	return
+}
+
+// EachRow calls fn for every row of a table, in primary key order (or rowid order for tables without a declared
+// primary key).  The rows are retrieved a page at a time using keyset pagination, so large tables can be processed
+// without holding them in memory.  Iteration stops when fn returns an error, which is then returned.  BLOB fields are handled as given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) EachRow(dbOwner, dbName, table string, fn func(ResultRow) error) (err error) {
+	return c.EachRowContext(context.Background(), dbOwner, dbName, table, fn)
+}
+
+// EachRowContext is like EachRow, but uses the given context for the requests.  Iteration also stops when the context
+// ends.
+func (c Connection) EachRowContext(ctx context.Context, dbOwner, dbName, table string, fn func(ResultRow) error) (err error) {
+	pk, err := c.PrimaryKeyContext(ctx, dbOwner, dbName, table)
+	if err != nil {
+		return
+	}
+	keyCols := "rowid"
+	if len(pk) != 0 {
+		keyCols = quoteIdentifiers(pk)
+	}
+	nKeys := len(pk)
+	if nKeys == 0 {
+		nKeys = 1
+	}
+
+	// The key columns are retrieved ahead of the others, to know where the next page starts.  Their numbers are kept
+	// exact, as integer keys beyond 2^53 would otherwise be rounded and the next page start in the wrong place
+	var last []interface{}
+	for {
+		sql := fmt.Sprintf("SELECT %s, * FROM %s", keyCols, quoteIdentifier(table))
+		if last != nil {
+			sql += fmt.Sprintf(" WHERE (%s) > (%s)", keyCols, strings.TrimSuffix(strings.Repeat("?, ", nKeys), ", "))
+		}
+		sql += fmt.Sprintf(" ORDER BY %s LIMIT %d", keyCols, eachRowPageSize)
+		sql, err = bindArgs(sql, last)
+		if err != nil {
+			return
+		}
+		var rows []com.DataRow
+		rows, err = c.querySQLExact(ctx, dbOwner, dbName, Identifier{}, sql)
+		if err != nil {
+			return
+		}
+		for _, j := range rows {
+			if len(j) < nKeys {
+				err = fmt.Errorf("unexpected number of fields (%d) in table row", len(j))
+				return
+			}
+			err = ctx.Err()
+			if err != nil {
+				return
+			}
+			err = fn(convertRow(j[nKeys:], c.DefaultBlobBase64))
+			if err != nil {
+				return
+			}
+		}
+		if len(rows) < eachRowPageSize {
+			return
+		}
+		last = last[:0]
+		for _, l := range rows[len(rows)-1][:nKeys] {
+			last = append(last, nativeValue(l))
+		}
+	}
+}
+
+// Select returns the given columns of the rows in a table matching a WHERE clause.  The table and column names are
+// quoted, so they don't need escaping.  An empty column list selects all columns.  The clause can contain "?"
+// placeholders, which are replaced with the safely quoted args, and an empty clause selects all rows.  BLOB fields are
+// handled as given by the DefaultBlobBase64 setting of the connection.
+func (c Connection) Select(dbOwner, dbName, table string, columns []string, where string, args ...interface{}) (out Results, err error) {
+	return c.SelectContext(context.Background(), dbOwner, dbName, table, columns, where, args...)
+}
+
+// SelectContext is like Select, but uses the given context for the request
+func (c Connection) SelectContext(ctx context.Context, dbOwner, dbName, table string, columns []string, where string, args ...interface{}) (out Results, err error) {
+	if table == "" {
+		err = fmt.Errorf("no table name given")
+		return
+	}
+	cols := "*"
+	if len(columns) != 0 {
+		for _, j := range columns {
+			if j == "" {
+				err = fmt.Errorf("empty column name given")
+				return
+			}
+		}
+		cols = quoteIdentifiers(columns)
+	}
+	sql := "SELECT " + cols + " FROM " + quoteIdentifier(table)
+	if strings.TrimSpace(where) != "" {
+		sql += " WHERE " + where
+	}
+	sql, err = bindArgs(sql, args)
+	if err != nil {
+		return
+	}
+	return c.QueryDefaultContext(ctx, dbOwner, dbName, Identifier{}, sql)
+}
+
+// TableDiff compares the rows of two tables with the same columns in a database, matching them up by the value of
+// pkColumn.  Rows of tableB without a match in tableA are returned as added, and rows of tableA without a match in
+// tableB as removed.  Rows present in both tables, but with different values, are returned as changed (with the
+// values from tableB).  Each set of rows is sorted by pkColumn.  BLOB fields are handled as given by the
+// DefaultBlobBase64 setting of the connection.
+func (c Connection) TableDiff(dbOwner, dbName, tableA, tableB, pkColumn string) (added, removed, changed Results, err error) {
+	return c.TableDiffContext(context.Background(), dbOwner, dbName, tableA, tableB, pkColumn)
+}
+
+// TableDiffContext is like TableDiff, but uses the given context for the requests
+func (c Connection) TableDiffContext(ctx context.Context, dbOwner, dbName, tableA, tableB, pkColumn string) (added, removed, changed Results, err error) {
+	if tableA == "" || tableB == "" {
+		err = fmt.Errorf("no table name given")
+		return
+	}
+	if pkColumn == "" {
+		err = fmt.Errorf("no primary key column given")
+		return
+	}
+	a, b, pk := quoteIdentifier(tableA), quoteIdentifier(tableB), quoteIdentifier(pkColumn)
+
+	// Rows only present in one of the tables.  The keys are compared with IS rather than NOT IN, as a single NULL key
+	// would otherwise make NOT IN match no rows at all
+	added, err = c.QueryDefaultContext(ctx, dbOwner, dbName, Identifier{}, fmt.Sprintf(
+		"SELECT * FROM %[2]s AS t WHERE NOT EXISTS (SELECT 1 FROM %[1]s AS o WHERE o.%[3]s IS t.%[3]s) ORDER BY %[3]s",
+		a, b, pk))
+	if err != nil {
+		return
+	}
+	removed, err = c.QueryDefaultContext(ctx, dbOwner, dbName, Identifier{}, fmt.Sprintf(
+		"SELECT * FROM %[1]s AS t WHERE NOT EXISTS (SELECT 1 FROM %[2]s AS o WHERE o.%[3]s IS t.%[3]s) ORDER BY %[3]s",
+		a, b, pk))
+	if err != nil {
+		return
+	}
+
+	// Rows present in both tables, which aren't identical
+	changed, err = c.QueryDefaultContext(ctx, dbOwner, dbName, Identifier{}, fmt.Sprintf(
+		"SELECT * FROM (SELECT * FROM %[2]s AS t WHERE EXISTS (SELECT 1 FROM %[1]s AS o WHERE o.%[3]s IS t.%[3]s) "+
+			"EXCEPT SELECT * FROM %[1]s) ORDER BY %[3]s", a, b, pk))
+	return
+}
+
+// TableHash returns a SHA256 hash (hex encoded) of the contents of a table.  The rows are hashed in primary key order
+// (or ordered by all of their columns for tables without a primary key), so tables holding the same data produce the
+// same hash no matter how they were populated.
+func (c Connection) TableHash(dbOwner, dbName, table string) (hash string, err error) {
+	return c.TableHashContext(context.Background(), dbOwner, dbName, table)
+}
+
+// TableHashContext is like TableHash, but uses the given context for the requests
+func (c Connection) TableHashContext(ctx context.Context, dbOwner, dbName, table string) (hash string, err error) {
+	// Work out the row order.  Without a primary key the rowids depend on the order the rows were inserted in, so
+	// every column is used instead.  Rows which are the same in every column are identical, so their order doesn't
+	// matter
+	columns, err := c.ColumnsContext(ctx, dbOwner, dbName, Identifier{}, table)
+	if err != nil {
+		return
+	}
+	order := primaryKey(columns)
+	if len(order) == 0 {
+		for _, j := range columns {
+			order = append(order, j.Name)
+		}
+	}
+
+	// Retrieve the table contents
+	sql := fmt.Sprintf("SELECT * FROM %s ORDER BY %s", quoteIdentifier(table), quoteIdentifiers(order))
+	res, err := c.QueryTypedContext(ctx, dbOwner, dbName, Identifier{}, sql)
+	if err != nil {
+		return
+	}
+
+	// Each field is tagged with its type and length prefixed, so NULLs, empty strings, and numbers stored as text
+	// can't produce the same hash as each other, and nor can values containing separator characters
+	h := sha256.New()
+	for _, row := range res.Rows {
+		fmt.Fprintf(h, "%d:", len(row))
+		for _, f := range row {
+			hashField(h, f)
+		}
+		io.WriteString(h, "\n")
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// hashField writes a typed field to a hash used by TableHash(), as a type tag followed by the value
+func hashField(w io.Writer, f TypedField) {
+	if f.IsNull {
+		io.WriteString(w, "n,")
+		return
+	}
+	var tag, value string
+	switch v := f.Value.(type) {
+	case int64:
+		tag, value = "i", strconv.FormatInt(v, 10)
+	case float64:
+		tag, value = "f", strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		tag, value = "t", v
+	case []byte:
+		tag, value = "b", string(v)
+	default:
+		tag, value = "?", fmt.Sprint(v)
+	}
+	fmt.Fprintf(w, "%s%d:%s,", tag, len(value), value)
+}
@@ -0,0 +1,239 @@
+package dbhub_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+	"github.com/sqlitebrowser/go-dbhub/dbhubtest"
+)
+
+// sentSQL returns the SQL sent with each query request received by the server
+func sentSQL(t *testing.T, s *dbhubtest.Server) (sql []string) {
+	t.Helper()
+	for _, req := range s.Requests() {
+		if req.Endpoint != "query" {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(req.Form.Get("sql"))
+		if err != nil {
+			t.Fatalf("decoding the SQL of a query: %v", err)
+		}
+		sql = append(sql, string(b))
+	}
+	return
+}
+
+func TestTableDiffMatchesKeysWithIs(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("query", 200, "[]")
+
+	_, _, _, err := s.Connection().TableDiff("me", "db.sqlite", "old", "new", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := sentSQL(t, s)
+	if len(sql) != 3 {
+		t.Fatalf("got %d queries, want 3", len(sql))
+	}
+	for _, q := range sql {
+		if strings.Contains(q, " IN (") {
+			t.Errorf("query matches keys with IN, which breaks with NULL keys: %s", q)
+		}
+		if !strings.Contains(q, `o."id" IS t."id"`) {
+			t.Errorf("query doesn't match keys with IS: %s", q)
+		}
+	}
+	if !strings.HasPrefix(sql[0], `SELECT * FROM "new" AS t WHERE NOT EXISTS (SELECT 1 FROM "old" AS o`) {
+		t.Errorf("added rows query is %s", sql[0])
+	}
+	if !strings.HasPrefix(sql[1], `SELECT * FROM "old" AS t WHERE NOT EXISTS (SELECT 1 FROM "new" AS o`) {
+		t.Errorf("removed rows query is %s", sql[1])
+	}
+}
+
+func TestTableHashKeepsTypes(t *testing.T) {
+	// Each case is a table with a single row holding one field, given as the query response for that row
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"null and empty text", `{"Name":"v","Type":2,"Value":null}`, `{"Name":"v","Type":3,"Value":""}`},
+		{"integer and text", `{"Name":"v","Type":4,"Value":1}`, `{"Name":"v","Type":3,"Value":"1"}`},
+		{"integer and float", `{"Name":"v","Type":4,"Value":2}`, `{"Name":"v","Type":5,"Value":2.5}`},
+		{"text and blob", `{"Name":"v","Type":3,"Value":"x"}`, `{"Name":"v","Type":0,"Value":"x"}`},
+	}
+	hash := func(t *testing.T, row string) string {
+		s := dbhubtest.NewServer()
+		defer s.Close()
+		s.Handle("columns", 200, `[{"column_id":0,"name":"v","data_type":"","primary_key":0}]`)
+		s.Handle("query", 200, "[["+row+"]]")
+		h, err := s.Connection().TableHash("me", "db.sqlite", "t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if hash(t, tt.a) == hash(t, tt.b) {
+				t.Errorf("%s and %s give the same hash", tt.a, tt.b)
+			}
+			if hash(t, tt.a) != hash(t, tt.a) {
+				t.Errorf("the same contents give different hashes")
+			}
+		})
+	}
+}
+
+func TestTableHashOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns string
+		wantSQL string
+	}{
+		{"primary key", `[{"column_id":0,"name":"name","primary_key":0},{"column_id":1,"name":"id","primary_key":1}]`,
+			`SELECT * FROM "t" ORDER BY "id"`},
+		{"composite primary key", `[{"column_id":0,"name":"a","primary_key":2},{"column_id":1,"name":"b","primary_key":1},` +
+			`{"column_id":2,"name":"c","primary_key":0}]`, `SELECT * FROM "t" ORDER BY "b", "a"`},
+		{"no primary key", `[{"column_id":0,"name":"a","primary_key":0},{"column_id":1,"name":"b c","primary_key":0}]`,
+			`SELECT * FROM "t" ORDER BY "a", "b c"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("columns", 200, tt.columns)
+			s.Handle("query", 200, "[]")
+			if _, err := s.Connection().TableHash("me", "db.sqlite", "t"); err != nil {
+				t.Fatal(err)
+			}
+			if sql := sentSQL(t, s); len(sql) != 1 || sql[0] != tt.wantSQL {
+				t.Errorf("sent SQL %q, want %q", sql, tt.wantSQL)
+			}
+		})
+	}
+}
+
+func TestCountWhere(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   string
+		where   string
+		args    []interface{}
+		count   string
+		want    int64
+		wantSQL string
+	}{
+		{"all rows", "t", "", nil, "3", 3, `SELECT COUNT(*) FROM "t"`},
+		{"bound parameter", "t", "a = ? AND b > ?", []interface{}{"x", 2}, "1", 1,
+			`SELECT COUNT(*) FROM "t" WHERE a = 'x' AND b > 2`},
+		{"injection in argument", "t", "name = ?", []interface{}{"x' OR '1'='1"}, "0", 0,
+			`SELECT COUNT(*) FROM "t" WHERE name = 'x'' OR ''1''=''1'`},
+		{"injection in table name", `t" WHERE 1=1; DROP TABLE "t`, "", nil, "0", 0,
+			`SELECT COUNT(*) FROM "t"" WHERE 1=1; DROP TABLE ""t"`},
+		{"million rows", "t", "", nil, "1000000", 1000000, `SELECT COUNT(*) FROM "t"`},
+		{"billions of rows", "t", "", nil, "12345678901", 12345678901, `SELECT COUNT(*) FROM "t"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			s.Handle("query", 200, `[[{"Name":"COUNT(*)","Type":4,"Value":`+tt.count+`}]]`)
+			got, err := s.Connection().CountWhere("me", "db.sqlite", tt.table, tt.where, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got count %d, want %d", got, tt.want)
+			}
+			if sql := sentSQL(t, s); len(sql) != 1 || sql[0] != tt.wantSQL {
+				t.Errorf("sent SQL %q, want %q", sql, tt.wantSQL)
+			}
+		})
+	}
+}
+
+func TestCountWhereErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		where string
+		args  []interface{}
+	}{
+		{"no table", "", "", nil},
+		{"missing argument", "t", "a = ?", nil},
+		{"unused argument", "t", "", []interface{}{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := dbhubtest.NewServer()
+			defer s.Close()
+			_, err := s.Connection().CountWhere("me", "db.sqlite", tt.table, tt.where, tt.args...)
+			if err == nil {
+				t.Error("no error returned")
+			}
+			if n := len(s.Requests()); n != 0 {
+				t.Errorf("%d requests sent, want none", n)
+			}
+		})
+	}
+}
+
+func TestColumnTypeHistogram(t *testing.T) {
+	s := dbhubtest.NewServer()
+	defer s.Close()
+	s.Handle("query", 200, `[[{"Name":"typeof(\"v\")","Type":3,"Value":"integer"},{"Name":"COUNT(*)","Type":4,"Value":1000000}],`+
+		`[{"Name":"typeof(\"v\")","Type":3,"Value":"text"},{"Name":"COUNT(*)","Type":4,"Value":2}]]`)
+	hist, err := s.Connection().ColumnTypeHistogram("me", "db.sqlite", "t", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hist) != 2 || hist["integer"] != 1000000 || hist["text"] != 2 {
+		t.Errorf("got histogram %v", hist)
+	}
+	if sql := sentSQL(t, s); len(sql) != 1 || sql[0] != `SELECT typeof("v"), COUNT(*) FROM "t" GROUP BY 1` {
+		t.Errorf("sent SQL %q", sql)
+	}
+}
+
+func TestEachRowLargeKeys(t *testing.T) {
+	// The keys are beyond 2^53, where a float64 can only hold even integers, and the last key of the first page is odd
+	const first = int64(1)<<53 + 2
+	var pages []string
+	s := newSQLServer(t, func(sql string) string {
+		if sql == "" {
+			return `[{"column_id":0,"name":"id","data_type":"INTEGER","primary_key":1}]`
+		}
+		pages = append(pages, sql)
+		if len(pages) > 1 {
+			return "[]"
+		}
+		var rows []string
+		for i := int64(0); i < 1000; i++ {
+			rows = append(rows, fmt.Sprintf(`[{"Name":"id","Type":4,"Value":%[1]d},{"Name":"id","Type":4,"Value":%[1]d}]`,
+				first+i))
+		}
+		return "[" + strings.Join(rows, ",") + "]"
+	})
+	defer s.Close()
+
+	var got []string
+	err := newConnection(t, s).EachRow("me", "db.sqlite", "t", func(row dbhub.ResultRow) error {
+		got = append(got, row.Fields[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1000 || got[0] != fmt.Sprint(first) || got[999] != fmt.Sprint(first+999) {
+		t.Errorf("got %d rows, from %v to %v", len(got), got[0], got[len(got)-1])
+	}
+	want := fmt.Sprintf(`SELECT "id", * FROM "t" WHERE ("id") > (%d) ORDER BY "id" LIMIT 1000`, first+999)
+	if len(pages) != 2 || pages[1] != want {
+		t.Errorf("sent queries %q, want the second to be %q", pages, want)
+	}
+}
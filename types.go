@@ -1,11 +1,254 @@
 package dbhub
 
-import "time"
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// AccessLevel specifies what a connection's API key is allowed to do with a database
+type AccessLevel string
+
+const (
+	// AccessNone is used when the database can't be accessed at all (or doesn't exist)
+	AccessNone AccessLevel = "none"
+
+	// AccessOwner is used for databases owned by the account of the API key
+	AccessOwner AccessLevel = "owner"
+
+	// AccessRead is used when the database can be read, but not changed
+	AccessRead AccessLevel = "read"
+
+	// AccessWrite is used when the database can be read and changed
+	AccessWrite AccessLevel = "write"
+)
+
+// Affinity is the SQLite type affinity of a column, which decides how values stored in it are converted
+type Affinity string
+
+const (
+	// AffinityBlob is used for columns declared with a type containing "BLOB", or without a type
+	AffinityBlob Affinity = "BLOB"
+
+	// AffinityInteger is used for columns declared with a type containing "INT"
+	AffinityInteger Affinity = "INTEGER"
+
+	// AffinityNumeric is used for columns declared with any other type (eg "DECIMAL(10,5)" or "BOOLEAN")
+	AffinityNumeric Affinity = "NUMERIC"
+
+	// AffinityReal is used for columns declared with a type containing "REAL", "FLOA", or "DOUB"
+	AffinityReal Affinity = "REAL"
+
+	// AffinityText is used for columns declared with a type containing "CHAR", "CLOB", or "TEXT"
+	AffinityText Affinity = "TEXT"
+)
+
+// BackupResult holds the outcome of backing up a single database with BackupAll()
+type BackupResult struct {
+	DBName string `json:"dbname"`
+	Path   string `json:"path"`
+	Err    error  `json:"-"`
+}
+
+// BatchQueryItem holds one of the queries run by BatchQuery()
+type BatchQueryItem struct {
+	DBOwner string     `json:"dbowner"`
+	DBName  string     `json:"dbname"`
+	Ident   Identifier `json:"identifier"`
+	SQL     string     `json:"sql"`
+}
+
+// BatchQueryResult holds the outcome of one of the queries run by BatchQuery()
+type BatchQueryResult struct {
+	Results Results `json:"results"`
+	Err     error   `json:"-"`
+}
+
+// CellChange holds a single value of a table which was changed between two commits, as returned by ColumnChanges().
+// The row is identified by its primary key values, keyed by column name.
+type CellChange struct {
+	PK     map[string]interface{} `json:"pk"`
+	Column string                 `json:"column"`
+	Old    interface{}            `json:"old"`
+	New    interface{}            `json:"new"`
+}
+
+// CloneInfo records where a local copy of a database made by Clone() came from, and which commit it's a copy of
+type CloneInfo struct {
+	Owner    string    `json:"dbowner"`
+	Name     string    `json:"dbname"`
+	Branch   string    `json:"branch"`
+	CommitID string    `json:"commit_id"`
+	Synced   time.Time `json:"synced"`
+}
+
+// ColumnInfo holds the details of a column as returned by Columns(), along with the type affinity worked out from its
+// declared type
+type ColumnInfo struct {
+	com.APIJSONColumn
+	ColumnAffinity Affinity `json:"affinity"`
+}
 
 // Connection is a simple container holding the API key and address of the DBHub.io server
 type Connection struct {
-	APIKey string `json:"api_key"`
-	Server string `json:"server"`
+	APIKey string      `json:"api_key"`
+	Server string      `json:"server"`
+	Retry  RetryPolicy `json:"retry"`
+
+	// APIPath is the path the API end points are under on the server.  If it's empty, the standard "/v1" is used.  Self
+	// hosted servers can mount the API under a different path (eg "/dbhub/v1")
+	APIPath string `json:"api_path"`
+
+	// Breaker is an optional circuit breaker, for avoiding sending requests to a server which keeps failing
+	Breaker *CircuitBreaker `json:"-"`
+
+	// HTTPClient is the client used for sending requests to the server.  If it's nil, a client without a timeout is
+	// used
+	HTTPClient *http.Client `json:"-"`
+
+	// Cache is an optional store for caching the responses of schema calls (eg Tables()) and queries, so repeated
+	// requests for the same commit don't need to go to the server.  Use NewMemoryCache() for an in-memory one
+	Cache Cache `json:"-"`
+
+	// CacheHeadTTL is how long the cache remembers the commit at the head of each branch, before asking the server
+	// again whether a new commit has been made.  If it's 0, 30 seconds is used.  Calling Branches() or Metadata()
+	// always updates the remembered heads
+	CacheHeadTTL time.Duration `json:"cache_head_ttl"`
+
+	// ConfirmDestructive is an optional check run before a database is deleted or renamed, guarding against accidents.
+	// It's given the action ("delete" or "rename") and the name of the database, and returning false cancels the
+	// action with ErrNotConfirmed
+	ConfirmDestructive func(action, dbName string) bool `json:"-"`
+
+	// OnRequest is an optional hook called for each request sent to the server (including retries), for logging and
+	// metrics.  It's called once the response body has been closed, or straight away if the request failed.  The
+	// context is the one the request was sent with, so tracing information can be taken from it
+	OnRequest func(ctx context.Context, info RequestInfo) `json:"-"`
+
+	// DefaultBlobBase64 is used by the query functions which don't take an explicit blobBase64 argument (eg
+	// QueryDefault()), to choose whether BLOB fields are base64 encoded in the output or left empty
+	DefaultBlobBase64 bool `json:"default_blob_base64"`
+}
+
+// Contributor holds the details of someone who authored commits to a database, as returned by Contributors()
+type Contributor struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Commits int    `json:"commits"`
+}
+
+// CSVOptions holds the options used when writing query results in CSV format
+type CSVOptions struct {
+	// Header adds a first row holding the column names
+	Header bool `json:"header"`
+
+	// Null is the text written for NULL fields.  Results from Query() can't tell NULLs apart from empty strings, so
+	// it's only used with typed results and the streaming QueryCSV()
+	Null string `json:"null"`
+}
+
+// DBHubAPI holds the methods of Connection which talk directly to the DBHub.io API end points.  Code which accepts it
+// rather than a Connection can be tested without a DBHub.io server, by giving it the fake from the dbhubtest package.
+type DBHubAPI interface {
+	AccessLevel(dbOwner, dbName string) (level AccessLevel, err error)
+	Branches(dbOwner, dbName string) (branches map[string]com.BranchEntry, defaultBranch string, err error)
+	Columns(dbOwner, dbName string, ident Identifier, table string) (columns []com.APIJSONColumn, err error)
+	Commits(dbOwner, dbName string) (commits map[string]com.CommitEntry, err error)
+	CreateWebhook(dbOwner, dbName, targetURL string, events []string) (id string, err error)
+	DatabaseLicence(dbOwner, dbName string) (licence com.LicenceEntry, err error)
+	Databases() (databases []string, err error)
+	Delete(dbName string) (err error)
+	DeleteWebhook(dbOwner, dbName, id string) (err error)
+	Diff(dbOwnerA, dbNameA string, identA Identifier, dbOwnerB, dbNameB string, identB Identifier, merge MergeStrategy) (diffs com.Diffs, err error)
+	Download(dbOwner, dbName string, ident Identifier) (db io.ReadCloser, err error)
+	Execute(dbOwner, dbName, sql string) (rowsChanged int64, err error)
+	ExecuteResult(dbOwner, dbName, sql string) (result ExecResult, err error)
+	Fork(dbOwner, dbName string) (newOwner, newName string, err error)
+	Indexes(dbOwner, dbName string, ident Identifier) (idx []com.APIJSONIndex, err error)
+	Labels(dbOwner, dbName string) (labels map[string]string, err error)
+	LiveDatabases() (databases []string, err error)
+	LiveLockStatus(dbOwner, dbName string) (locked bool, holder string, err error)
+	Lock(dbOwner, dbName string) (unlock func() error, err error)
+	Merge(dbOwner, dbName, sourceBranch, targetBranch, message string) (commitID string, err error)
+	Metadata(dbOwner, dbName string) (meta com.MetadataResponseContainer, err error)
+	Query(dbOwner, dbName string, ident Identifier, blobBase64 bool, sql string) (out Results, err error)
+	QueryDefault(dbOwner, dbName string, ident Identifier, sql string) (out Results, err error)
+	QueryPrepared(dbOwner, dbName, sql string, args []interface{}) (out Results, err error)
+	Releases(dbOwner, dbName string) (releases map[string]com.ReleaseEntry, err error)
+	Rename(dbName, newName string) (err error)
+	ServerTime() (serverTime time.Time, skew time.Duration, err error)
+	SetDatabaseLicence(dbOwner, dbName, licence string) (err error)
+	SetLabel(dbOwner, dbName, key, value string) (err error)
+	Star(dbOwner, dbName string) (err error)
+	Tables(dbOwner, dbName string, ident Identifier) (tbl []string, err error)
+	Tags(dbOwner, dbName string) (tags map[string]com.TagEntry, err error)
+	Unstar(dbOwner, dbName string) (err error)
+	Unwatch(dbOwner, dbName string) (err error)
+	Upload(dbName string, info UploadInformation, dbBytes *[]byte) (err error)
+	UploadReader(dbName string, db io.Reader, info UploadInformation) (commitID string, err error)
+	UserDatabases(userName string) (databases []com.DBEntry, err error)
+	Views(dbOwner, dbName string, ident Identifier) (views []string, err error)
+	Watch(dbOwner, dbName string) (err error)
+	Webhooks(dbOwner, dbName string) (hooks []Webhook, err error)
+	Webpage(dbOwner, dbName string) (webPage com.WebpageResponseContainer, err error)
+}
+
+// DatabaseInfo holds the name and owner of a database along with an overview of it, as returned by DatabasesForUser()
+type DatabaseInfo struct {
+	Owner  string `json:"owner"`
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+	DatabaseSummary
+}
+
+// DatabaseSummary holds an overview of a database, as returned by Summary()
+type DatabaseSummary struct {
+	DefaultBranch string    `json:"default_branch"`
+	HeadCommit    string    `json:"head_commit"`
+	CommitCount   int       `json:"commit_count"` // The number of commits on the default branch
+	Size          int64     `json:"size"`         // The size in bytes of the database file at the head commit
+	LastModified  time.Time `json:"last_modified"`
+	Branches      int       `json:"branches"`
+	Tags          int       `json:"tags"`
+	Releases      int       `json:"releases"`
+}
+
+// ExecResult holds the outcome of running a statement with ExecuteResult(), or one statement of a script with
+// ExecScript().  LastInsertID is the rowid of the last row inserted, and is 0 if the server doesn't report it.
+type ExecResult struct {
+	SQL          string `json:"sql"`
+	RowsChanged  int64  `json:"rows_changed"`
+	LastInsertID int64  `json:"last_insert_id"`
+}
+
+// ExecScriptOptions holds the options used when running a script with ExecScript()
+type ExecScriptOptions struct {
+	// SplitStatements runs each statement of the script separately, instead of sending the whole script at once
+	SplitStatements bool `json:"split_statements"`
+}
+
+// FKViolation holds the details of a row violating a foreign key constraint, as returned by CheckForeignKeys().  FKID
+// matches the ID of the constraint as returned by ForeignKeys() for the table.  RowID is 0 for WITHOUT ROWID tables.
+type FKViolation struct {
+	Table  string `json:"table"`
+	RowID  int64  `json:"rowid"`
+	Parent string `json:"parent"`
+	FKID   int    `json:"fkid"`
+}
+
+// ForeignKey holds the details of one column of a foreign key constraint.  Foreign keys spanning multiple columns
+// have one entry per column, sharing the same ID and ordered by Seq
+type ForeignKey struct {
+	ID       int    `json:"id"`
+	Seq      int    `json:"seq"`
+	Table    string `json:"table"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	OnUpdate string `json:"on_update"`
+	OnDelete string `json:"on_delete"`
 }
 
 // Identifier holds information used to identify a specific commit, tag, release, or the head of a specific branch
@@ -18,7 +261,8 @@ type Identifier struct {
 
 // JSONError holds information about an error condition, in a useful JSON format
 type JSONError struct {
-	Msg string `json:"error"`
+	Msg    string `json:"error"`
+	Status string `json:"status,omitempty"`
 }
 
 // MergeStrategy specifies the type of SQL statements included in the diff results.
@@ -39,14 +283,115 @@ const (
 	NewPkMerge
 )
 
+// NamedArg is a value for a named placeholder (eg ":name", "@name", or "$name") in a SQL statement, as created by
+// Named()
+type NamedArg struct {
+	Name  string
+	Value interface{}
+}
+
+// PagedResults holds one page of the results of a SQL query, along with the details needed for paging through the rest
+type PagedResults struct {
+	Results
+	Page      int   `json:"page"`
+	PageSize  int   `json:"page_size"`
+	TotalRows int64 `json:"total_rows"`
+	HasNext   bool  `json:"has_next"`
+}
+
+// QueryTypedOptions holds the options used when running a query with QueryTypedWithOptions()
+type QueryTypedOptions struct {
+	// TimeColumns are the names of the result columns holding timestamps, which are decoded into time.Time values.
+	// SQLite has no time type, so timestamps are stored either as ISO-8601 text (eg "2006-01-02 15:04:05" or RFC
+	// 3339), or as integer (or floating point) seconds since the Unix epoch.  NULLs are left as they are.
+	TimeColumns []string `json:"time_columns"`
+
+	// Location is the time zone the decoded times are given in, which is also assumed for ISO-8601 text without one.
+	// If nil, UTC is used.
+	Location *time.Location `json:"-"`
+}
+
+// RequestInfo holds the details of a request sent to the DBHub.io server, as given to the OnRequest hook of a
+// connection
+type RequestInfo struct {
+	Endpoint     string        // The name of the API end point (eg "query")
+	Attempt      int           // 0 for the first attempt, 1 for the first retry, etc
+	Duration     time.Duration // The time from sending the request until the response body was closed
+	RequestSize  int64         // The number of bytes sent in the request body
+	ResponseSize int64         // The number of bytes read from the response body
+	StatusCode   int           // The HTTP status code of the response, or 0 if there wasn't one
+	Err          error         // The error which stopped the request getting a response, if any
+}
+
 // ResultRow is used for returning the results of a SQL query as a slice of strings
 type ResultRow struct {
 	Fields []string
 }
 
-// Results is used for returning the results of a SQL query as a slice of strings
+// Results is used for returning the results of a SQL query as a slice of strings.  ColNames holds the names of the
+// result columns, when the query returned at least one row
 type Results struct {
-	Rows []ResultRow
+	ColNames []string
+	Rows     []ResultRow
+}
+
+// RetryPolicy controls the automatic retrying of requests which fail due to network errors, server side problems, or
+// rate limiting.  Only requests which are safe to repeat are retried, unless an idempotency key has been given using
+// WithIdempotencyKey().  Uploads are never retried.  When the server gives a Retry-After header, it's used instead of
+// the calculated pause (still limited by MaxDelay).
+type RetryPolicy struct {
+	MaxRetries int           `json:"max_retries"` // The number of times a failed request is retried.  0 disables retries
+	Delay      time.Duration `json:"delay"`       // The pause before the first retry
+	Backoff    float64       `json:"backoff"`     // What the pause is multiplied by after each retry (eg 2).  0 or 1 keeps it constant
+	MaxDelay   time.Duration `json:"max_delay"`   // The longest pause between attempts.  0 means no limit
+	Jitter     bool          `json:"jitter"`      // Randomises each pause to between half and all of its length
+}
+
+// Template is implemented by the templates of both the text/template and html/template packages, for use with
+// Results.Execute()
+type Template interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// TimelineEntry holds a single commit, tag, or release in the timeline of a database
+type TimelineEntry struct {
+	Kind        TimelineKind `json:"kind"`
+	Name        string       `json:"name"` // The tag or release name, or the commit ID for commits
+	Commit      string       `json:"commit"`
+	Date        time.Time    `json:"date"`
+	Description string       `json:"description"` // The commit message for commits
+	AuthorName  string       `json:"author_name"`
+	AuthorEmail string       `json:"author_email"`
+}
+
+// TimelineKind specifies the type of a timeline entry
+type TimelineKind string
+
+const (
+	// TimelineCommit is used for commits
+	TimelineCommit TimelineKind = "commit"
+
+	// TimelineRelease is used for releases
+	TimelineRelease TimelineKind = "release"
+
+	// TimelineTag is used for tags
+	TimelineTag TimelineKind = "tag"
+)
+
+// TypedField holds a single field of a query result along with its SQLite type, as returned by QueryTyped().  The
+// value is a native Go value: int64 for integers, float64 for floating point numbers, string for text, and []byte for
+// BLOBs.  NULL fields have IsNull set and a nil value, so they can be told apart from empty strings and zero values.
+// Fields of the time columns given to QueryTypedWithOptions() hold a time.Time instead, keeping their SQLite type.
+type TypedField struct {
+	Type   com.ValType `json:"type"`
+	Value  interface{} `json:"value"`
+	IsNull bool        `json:"is_null"`
+}
+
+// TypedResults is the set of rows returned by QueryTyped(), with each field keeping its type
+type TypedResults struct {
+	ColNames []string       `json:"col_names"`
+	Rows     [][]TypedField `json:"rows"`
 }
 
 // UploadInformation holds information used when uploading
@@ -66,3 +411,10 @@ type UploadInformation struct {
 	OtherParents    string     `json:"otherparents"`
 	ShaSum          string     `json:"dbshasum"`
 }
+
+// Webhook holds the details of a webhook registered for a database, as returned by Webhooks()
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
@@ -0,0 +1,30 @@
+package dbhub
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// maxDBNameLength is the longest database name accepted by DBHub.io
+const maxDBNameLength = 256
+
+// ValidDatabaseName checks whether a database name is acceptable to DBHub.io, so problems can be reported before
+// attempting an upload.  The rules are the ones used by the server: between 1 and 256 characters long, using only
+// letters, digits, spaces, and the characters ".-_()+".  No particular file extension is required.
+func ValidDatabaseName(name string) error {
+	if name == "" {
+		return fmt.Errorf("database name is empty")
+	}
+	if utf8.RuneCountInString(name) > maxDBNameLength {
+		return fmt.Errorf("database name is longer than %d characters", maxDBNameLength)
+	}
+
+	// The server side validation code is the final authority
+	if com.ValidateDB(name) != nil {
+		return fmt.Errorf("database name '%s' contains characters other than letters, digits, spaces, and '.-_()+'",
+			name)
+	}
+	return nil
+}
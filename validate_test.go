@@ -0,0 +1,34 @@
+package dbhub_test
+
+import (
+	"strings"
+	"testing"
+
+	dbhub "github.com/sqlitebrowser/go-dbhub"
+)
+
+func TestValidDatabaseName(t *testing.T) {
+	tests := []struct {
+		name    string
+		dbName  string
+		wantErr bool
+	}{
+		{"simple", "db.sqlite", false},
+		{"spaces and punctuation", "Join Testing (v2)+final_-.sqlite", false},
+		{"no extension", "mydata", false},
+		{"longest", strings.Repeat("a", 256), false},
+		{"empty", "", true},
+		{"too long", strings.Repeat("a", 257), true},
+		{"slash", "dir/db.sqlite", true},
+		{"quote", "it's.sqlite", true},
+		{"semicolon", "db;.sqlite", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dbhub.ValidDatabaseName(tt.dbName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}